@@ -1,11 +1,11 @@
 package caddyconfig
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
 	"github.com/caddyserver/caddy/v2"
-	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
 	"github.com/ccmonky/caddy-config/http"
@@ -50,9 +50,24 @@ type Config struct {
 
 	ExtensionRaw map[string]json.RawMessage `json:"ext,omitempty"`
 
-	readyMods map[string]Ready
-	ctx       caddy.Context
-	logger    *zap.Logger
+	// ReadyTimeout is the max time to wait in Start until all Ready modules are ready, default 0 means check only once
+	ReadyTimeout caddy.Duration `json:"ready_timeout,omitempty"`
+
+	// ReadyBackoff is the backoff of retrying the Ready modules not ready in Start, see ReadyTimeout
+	ReadyBackoff *ReadyBackoff `json:"ready_backoff,omitempty"`
+
+	// HealthInterval is the interval of checking Ready modules in background after Start, default 10s, negative disables it
+	HealthInterval caddy.Duration `json:"health_interval,omitempty"`
+
+	// StopTimeout is the max time to wait in Stop until all resources are cleaned up, default 10s
+	StopTimeout caddy.Duration `json:"stop_timeout,omitempty"`
+
+	resources   []resource
+	readyMods   map[string]Ready
+	readyStatus *readyStatus
+	health      *health
+	ctx         caddy.Context
+	logger      *zap.Logger
 }
 
 type InitHook struct {
@@ -61,7 +76,8 @@ type InitHook struct {
 	Attrs   map[string]json.RawMessage `json:"attrs,omitempty"`
 }
 
-// Ready 用于执行就绪检查，有些tproxy插件在Provision和Validate阶段无法做引用资源有效性检查
+// Ready 用于执行就绪检查，有些tproxy插件在Provision和Validate阶段无法做引用资源有效性检查，
+// Config直接加载的模块实现Ready即会被收集，嵌套加载的模块需通过`RegisterReady`登记
 type Ready interface {
 	Ready() error
 }
@@ -74,15 +90,19 @@ func (Config) ID() string {
 // CaddyModule returns the Caddy module information.
 func (c Config) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
-		ID:  c.ID(),
+		ID:  caddy.ModuleID(c.ID()),
 		New: func() caddy.Module { return new(Config) },
 	}
 }
 
 // Provision sets up the app.
 func (c *Config) Provision(ctx caddy.Context) error {
-	c.ctx = ctx
 	c.logger = ctx.Logger(c)
+	c.readyMods = make(map[string]Ready)
+	c.readyStatus = &readyStatus{mods: make(map[string]*ReadyStatus)}
+	// NOTE: 传递给嵌套加载的模块，见`RegisterReady`
+	ctx.Context = context.WithValue(ctx.Context, configKey{}, c)
+	c.ctx = ctx
 	err := ProvisionPreApps(ctx, c.ID(), c.PreApps)
 	if err != nil {
 		return err
@@ -109,6 +129,7 @@ func (c *Config) Provision(ctx caddy.Context) error {
 		if err != nil {
 			return err
 		}
+		c.register("config.logging", c.Logging)
 	}
 
 	if c.Tracers != nil {
@@ -116,23 +137,24 @@ func (c *Config) Provision(ctx caddy.Context) error {
 		if err != nil {
 			return err
 		}
+		c.register("config.tracers", c.Tracers)
 	}
 
-	c.readyMods = make(map[string]Ready)
-
 	for typ, rawMsg := range c.EigenkeyRaw {
-		_, err := ctx.LoadModuleByID("config.eigenkey."+typ, rawMsg)
+		mod, err := ctx.LoadModuleByID("config.eigenkey."+typ, rawMsg)
 		if err != nil {
 			return fmt.Errorf("loading eigenkey config module in position %s: %v", typ, err)
 		}
+		c.register("config.eigenkey."+typ, mod)
 	}
 	c.EigenkeyRaw = nil // allow GC to deallocate
 
 	for driver, rawMsg := range c.StoreRaw {
-		_, err := ctx.LoadModuleByID("config.store."+driver, rawMsg)
+		mod, err := ctx.LoadModuleByID("config.store."+driver, rawMsg)
 		if err != nil {
 			return fmt.Errorf("loading store config module in position %s: %v", driver, err)
 		}
+		c.register("config.store."+driver, mod)
 	}
 	c.StoreRaw = nil // allow GC to deallocate
 
@@ -142,6 +164,7 @@ func (c *Config) Provision(ctx caddy.Context) error {
 			if err != nil {
 				return err
 			}
+			c.register("config.mock.matchers", c.Mock.Matchers)
 		}
 	}
 
@@ -151,12 +174,14 @@ func (c *Config) Provision(ctx caddy.Context) error {
 			if err != nil {
 				return err
 			}
+			c.register("config.http.clients", c.HTTP.Clients)
 		}
 		if c.HTTP.RequestBuilders != nil {
 			err := c.HTTP.RequestBuilders.Provision(ctx)
 			if err != nil {
 				return err
 			}
+			c.register("config.http.request_builders", c.HTTP.RequestBuilders)
 		}
 	}
 
@@ -165,13 +190,19 @@ func (c *Config) Provision(ctx caddy.Context) error {
 		if err != nil {
 			return err
 		}
+		c.register("config.pool", c.Pool)
 	}
 
 	for name, rawMsg := range c.ExtensionRaw {
-		_, err := ctx.LoadModuleByID("config.goapp."+name, rawMsg)
+		id, err := extensionID(name)
+		if err != nil {
+			return err
+		}
+		mod, err := ctx.LoadModuleByID(id, rawMsg)
 		if err != nil {
-			return fmt.Errorf("loading goapp config module in position %s: %v", name, err)
+			return fmt.Errorf("loading ext config module %s in position %s: %v", id, name, err)
 		}
+		c.register(id, mod)
 	}
 	c.ExtensionRaw = nil // allow GC to deallocate
 
@@ -182,18 +213,38 @@ func (c *Config) Provision(ctx caddy.Context) error {
 			if err != nil {
 				return err
 			}
+			c.register("config.http.handlers", c.HTTP.Handlers)
 		}
 		if c.HTTP.MatcherSets != nil {
 			err := c.HTTP.MatcherSets.Provision(ctx)
 			if err != nil {
 				return err
 			}
+			c.register("config.http.matcher_sets", c.HTTP.MatcherSets)
 		}
 	}
 
 	return nil
 }
 
+// extensionID 返回扩展name的模块id，即`config.ext.<name>`或兼容goapp扩展的`config.goapp.<name>`，
+// 两者都已注册时返回错误，避免同名扩展被静默遮蔽
+func extensionID(name string) (string, error) {
+	ext, goapp := "config.ext."+name, "config.goapp."+name
+	_, extErr := caddy.GetModule(ext)
+	_, goappErr := caddy.GetModule(goapp)
+	switch {
+	case extErr == nil && goappErr == nil:
+		return "", fmt.Errorf("ext %s is ambiguous: both %s and %s are registered", name, ext, goapp)
+	case extErr == nil:
+		return ext, nil
+	case goappErr == nil:
+		return goapp, nil
+	default:
+		return "", fmt.Errorf("unknown ext %s: neither %s nor %s is registered", name, ext, goapp)
+	}
+}
+
 // Validate ensures the app's configuration is valid.
 func (c *Config) Validate() error {
 	// if c.Registries != nil {
@@ -226,21 +277,23 @@ func (c *Config) Validate() error {
 // Start runs the app
 func (c *Config) Start() error {
 	// 0. NOTE: 这实际上是在做Validate操作，当前主要场景是sso需要验证jwtauth的实例是否合法！
-	for name, readyMod := range c.readyMods {
-		err := readyMod.Ready()
-		if err != nil {
-			return errors.Errorf("mod %s Ready check failed: %v", name, err)
-		}
+	err := c.waitReady()
+	if err != nil {
+		return err
 	}
+	c.startHealth()
+	setRunning(c)
 	// 1. 注册BuiltinAPIRouter
 	// NOTE: 为什么放这里？因此有些如sso、errorspace可能是在provision里才注册BuiltinAPIRouter，此处Provision已经全部执行完毕！
 	//return registerBuiltinAPIRouters()
 	return nil
 }
 
-// Stop gracefully shuts down the HTTP server.
+// Stop stops the background health checks and cleans up the resources in reverse provisioning order
 func (c *Config) Stop() error {
-	return nil
+	unsetRunning(c)
+	c.stopHealth()
+	return c.cleanup()
 }
 
 // Interface guard
@@ -0,0 +1,52 @@
+package caddyconfig_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	caddyconfig "github.com/ccmonky/caddy-config"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	caddy.RegisterModule(extension{id: "config.ext.test_ext"})
+	caddy.RegisterModule(extension{id: "config.goapp.test_goapp"})
+	caddy.RegisterModule(extension{id: "config.ext.test_both"})
+	caddy.RegisterModule(extension{id: "config.goapp.test_both"})
+}
+
+type extension struct {
+	id caddy.ModuleID
+}
+
+func (e extension) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  e.id,
+		New: func() caddy.Module { return new(extension) },
+	}
+}
+
+func TestExtensionID(t *testing.T) {
+	provision := func(data string) error {
+		c := &caddyconfig.Config{}
+		err := json.Unmarshal([]byte(data), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+		defer cancel()
+		return c.Provision(ctx)
+	}
+	err := provision(`{"ext": {"test_ext": {}}}`)
+	assert.Nilf(t, err, "config.ext extension")
+	err = provision(`{"ext": {"test_goapp": {}}}`)
+	assert.Nilf(t, err, "config.goapp extension")
+	err = provision(`{"ext": {"test_both": {}}}`)
+	assert.NotNilf(t, err, "ambiguous extension")
+	assert.Containsf(t, err.Error(), "ambiguous", "ambiguous extension")
+	err = provision(`{"ext": {"test_missing": {}}}`)
+	assert.NotNilf(t, err, "unknown extension")
+	assert.Containsf(t, err.Error(), "neither config.ext.test_missing nor config.goapp.test_missing", "unknown extension")
+}
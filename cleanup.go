@@ -0,0 +1,103 @@
+package caddyconfig
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+// DefaultStopTimeout Stop时等待资源清理的默认超时时间
+const DefaultStopTimeout = 10 * time.Second
+
+// resource Provision产生的模块
+type resource struct {
+	name string
+	mod  any
+}
+
+// cleanup 按Provision的逆序清理资源，依次尝试caddy.CleanerUpper和io.Closer，返回合并后的错误；超过StopTimeout则
+// 不再等待，正在清理及剩余的资源在后台继续清理，并在完成时记录日志
+//
+// NOTE: caddy在Stop之后取消context时会以随机顺序再次调用通过caddy.Context加载的模块的caddy.CleanerUpper，且不受
+// StopTimeout限制，因此这些模块也在此按序清理，其Cleanup需要支持重复调用
+func (c *Config) cleanup() error {
+	timeout := time.Duration(c.StopTimeout)
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+	resources := c.resources
+	c.resources = nil
+	if len(resources) == 0 {
+		return nil
+	}
+	var (
+		mu       sync.Mutex
+		errs     error
+		current  = len(resources) - 1
+		timedOut bool
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := len(resources) - 1; i >= 0; i-- {
+			mu.Lock()
+			current = i
+			mu.Unlock()
+			if err := resources[i].cleanup(); err != nil {
+				mu.Lock()
+				errs = multierr.Append(errs, err)
+				mu.Unlock()
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if timedOut {
+			c.logger.Warn("cleanup resources finished in background after timeout", zap.Error(errs))
+		}
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		mu.Lock()
+		timedOut = true
+		pending := make([]string, 0, current)
+		for i := current - 1; i >= 0; i-- {
+			pending = append(pending, resources[i].name)
+		}
+		err = errors.Errorf("cleanup resources timeout after %v, blocked on %s, continue in background", timeout, resources[current].name)
+		c.logger.Warn("cleanup resources timeout, continue in background",
+			zap.Duration("timeout", timeout),
+			zap.String("blocked", resources[current].name),
+			zap.Strings("pending", pending))
+		mu.Unlock()
+	}
+	mu.Lock()
+	err = multierr.Append(err, errs)
+	mu.Unlock()
+	if err != nil {
+		c.logger.Error("cleanup resources failed", zap.Error(err))
+	}
+	return err
+}
+
+func (r resource) cleanup() error {
+	var err error
+	switch mod := r.mod.(type) {
+	case caddy.CleanerUpper:
+		err = mod.Cleanup()
+	case io.Closer:
+		err = mod.Close()
+	default:
+		return nil
+	}
+	if err != nil {
+		return errors.Errorf("cleanup %s failed: %v", r.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package caddyconfig_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	_ "github.com/ccmonky/caddy-config/dynconf"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	caddy.RegisterModule(CleanerListener{})
+	caddy.RegisterModule(CleanerStore{})
+}
+
+var cleaned struct {
+	sync.Mutex
+	names []string
+}
+
+func cleanedNames() []string {
+	cleaned.Lock()
+	defer cleaned.Unlock()
+	return append([]string(nil), cleaned.names...)
+}
+
+func resetCleaned() {
+	cleaned.Lock()
+	defer cleaned.Unlock()
+	cleaned.names = nil
+}
+
+// Cleaner records the cleanup order, and returns Error after blocking for Block
+type Cleaner struct {
+	Name  string         `json:"name,omitempty"`
+	Error string         `json:"error,omitempty"`
+	Block caddy.Duration `json:"block,omitempty"`
+}
+
+func (c *Cleaner) Cleanup() error {
+	cleaned.Lock()
+	cleaned.names = append(cleaned.names, c.Name)
+	cleaned.Unlock()
+	time.Sleep(time.Duration(c.Block))
+	if c.Error != "" {
+		return errors.New(c.Error)
+	}
+	return nil
+}
+
+type CleanerListener struct {
+	Cleaner
+}
+
+func (CleanerListener) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "config.ext.dynconf.listeners.test_cleaner",
+		New: func() caddy.Module { return new(CleanerListener) },
+	}
+}
+
+type CleanerStore struct {
+	Cleaner
+}
+
+func (CleanerStore) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "config.store.test_cleaner",
+		New: func() caddy.Module { return new(CleanerStore) },
+	}
+}
+
+func TestStopCleanupOrder(t *testing.T) {
+	resetCleaned()
+	c := newConfig(t, `{
+		"store": {"test_cleaner": {"name": "store"}},
+		"ext": {
+			"dynconf": {
+				"callbacks": {},
+				"listeners": [
+					{"listener": "test_cleaner", "name": "listener1"},
+					{"listener": "test_cleaner", "name": "listener2"}
+				]
+			}
+		}
+	}`)
+	err := c.Stop()
+	assert.Nilf(t, err, "stop")
+	assert.Equalf(t, []string{"listener2", "listener1", "store"}, cleanedNames(), "reverse order")
+}
+
+func TestStopCleanupErrors(t *testing.T) {
+	resetCleaned()
+	c := newConfig(t, `{
+		"store": {"test_cleaner": {"name": "store", "error": "store failed"}},
+		"ext": {
+			"dynconf": {
+				"callbacks": {},
+				"listeners": [
+					{"listener": "test_cleaner", "name": "listener1", "error": "listener1 failed"},
+					{"listener": "test_cleaner", "name": "listener2"}
+				]
+			}
+		}
+	}`)
+	err := c.Stop()
+	assert.NotNilf(t, err, "stop")
+	assert.Containsf(t, err.Error(), "listener1 failed", "listener error")
+	assert.Containsf(t, err.Error(), "config.ext.dynconf", "listener error")
+	assert.Containsf(t, err.Error(), "store failed", "store error")
+	assert.Containsf(t, err.Error(), "config.store.test_cleaner", "store error")
+	assert.Equalf(t, []string{"listener2", "listener1", "store"}, cleanedNames(), "continue after errors")
+}
+
+func TestStopCleanupTimeout(t *testing.T) {
+	resetCleaned()
+	c := newConfig(t, `{
+		"store": {"test_cleaner": {"name": "store"}},
+		"ext": {
+			"dynconf": {
+				"callbacks": {},
+				"listeners": [
+					{"listener": "test_cleaner", "name": "listener1", "block": "300ms"}
+				]
+			}
+		},
+		"stop_timeout": "50ms"
+	}`)
+	start := time.Now()
+	err := c.Stop()
+	assert.Lessf(t, time.Since(start), 300*time.Millisecond, "stop timeout")
+	assert.NotNilf(t, err, "stop timeout")
+	assert.Containsf(t, err.Error(), "timeout", "stop timeout")
+	assert.Containsf(t, err.Error(), "blocked on config.ext.dynconf", "stop timeout")
+	assert.Equalf(t, []string{"listener1"}, cleanedNames(), "remaining not cleaned before timeout")
+	assert.Eventuallyf(t, func() bool {
+		return len(cleanedNames()) == 2
+	}, 3*time.Second, 10*time.Millisecond, "remaining cleaned in background")
+}
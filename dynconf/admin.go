@@ -0,0 +1,298 @@
+package dynconf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/ccmonky/typemap"
+)
+
+func init() {
+	caddy.RegisterModule(AdminAPI{})
+}
+
+// AdminAPIPrefix is the prefix of dynconf admin api routes
+const AdminAPIPrefix = "/config-ext/dynconf/"
+
+// AdminSourceKey is the default sourceKey used when executing callback through admin api
+const AdminSourceKey = "admin"
+
+// AdminAPI serves dynconf admin api for inspection and manual override, it is a break-glass way to update
+// config when the config center is down:
+//
+// - GET  /config-ext/dynconf/callbacks: list all registered callbacks
+// - GET  /config-ext/dynconf/callback?key={key}: show a callback, with redacted current value and last update time if it is a RegCallback
+// - POST /config-ext/dynconf/callback?key={key}[&source_key={source_key}]: execute a callback with request body as data
+// - GET  /config-ext/dynconf/history?name={name}: list history of a RegCallback typemap instance name
+// - POST /config-ext/dynconf/rollback?name={name}&revision={revision}: rollback a RegCallback typemap instance name
+// - GET  /config-ext/dynconf/schemas[?name={name}]: export json schemas of RegCallbacks keyed by typemap instance name
+// - GET  /config-ext/dynconf/throttles: show statistics of throttled data keyed by callback key
+// - GET  /config-ext/dynconf/freshness: show the freshness of data keyed by sourceKey, i.e. from snapshot or live
+type AdminAPI struct{}
+
+// CallbackInfo is the information of a registered callback
+type CallbackInfo struct {
+	Key  string `json:"key"`
+	Type string `json:"type"`
+	// Name is the typemap instance name if callback is a Valuer(e.g. RegCallback)
+	Name string `json:"name,omitempty"`
+	// Value is the redacted current value, see `Redact`
+	Value      any        `json:"value,omitempty"`
+	ValueError string     `json:"value_error,omitempty"`
+	Revision   int64      `json:"revision,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.dynconf",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+// Routes implement caddy.AdminRouter
+func (a AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: AdminAPIPrefix,
+			Handler: caddy.AdminHandlerFunc(a.handleAPIEndpoints),
+		},
+	}
+}
+
+func (a AdminAPI) handleAPIEndpoints(w http.ResponseWriter, r *http.Request) error {
+	switch r.URL.Path {
+	case AdminAPIPrefix + "callbacks":
+		return a.handleCallbacks(w, r)
+	case AdminAPIPrefix + "callback":
+		return a.handleCallback(w, r)
+	case AdminAPIPrefix + "history":
+		return a.handleHistory(w, r)
+	case AdminAPIPrefix + "rollback":
+		return a.handleRollback(w, r)
+	case AdminAPIPrefix + "schemas":
+		return a.handleSchemas(w, r)
+	case AdminAPIPrefix + "throttles":
+		return a.handleThrottles(w, r)
+	case AdminAPIPrefix + "freshness":
+		return a.handleFreshness(w, r)
+	}
+	return caddy.APIError{
+		HTTPStatus: http.StatusNotFound,
+		Err:        fmt.Errorf("resource not found: %v", r.URL.Path),
+	}
+}
+
+func (a AdminAPI) handleCallbacks(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(r)
+	}
+	callbacks, err := typemap.GetAll[Callback](r.Context())
+	if err != nil && !isNotFound(err) {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        fmt.Errorf("get all callbacks failed: %v", err),
+		}
+	}
+	infos := make([]CallbackInfo, 0, len(callbacks))
+	for key, cb := range callbacks {
+		infos = append(infos, callbackInfo(r, fmt.Sprint(key), cb))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+	return writeJSON(w, infos)
+}
+
+func (a AdminAPI) handleCallback(w http.ResponseWriter, r *http.Request) error {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("key is required"),
+		}
+	}
+	cb, err := typemap.Get[Callback](r.Context(), key)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if isNotFound(err) {
+			status = http.StatusNotFound
+		}
+		return caddy.APIError{
+			HTTPStatus: status,
+			Err:        fmt.Errorf("get callback %s failed: %v", key, err),
+		}
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("read request body failed: %v", err),
+			}
+		}
+		sourceKey := r.URL.Query().Get("source_key")
+		if sourceKey == "" {
+			sourceKey = AdminSourceKey
+		}
+		// NOTE: not the request context, which is canceled before the data delayed by throttle is applied
+		err = callCallback(context.Background(), key, cb, sourceKey, string(body), nil)
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: callbackStatus(err),
+				Err:        fmt.Errorf("execute callback %s failed: %v", key, err),
+			}
+		}
+	default:
+		return methodNotAllowed(r)
+	}
+	return writeJSON(w, callbackInfo(r, key, cb))
+}
+
+// callbackStatus returns the http status of the error returned by callback: 400 if data is invalid, 504 if timeout,
+// otherwise 500
+func callbackStatus(err error) int {
+	var schemaErr *SchemaError
+	switch {
+	case IsValidationError(err), errors.As(err, &schemaErr):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+func (a AdminAPI) handleHistory(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(r)
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		return writeJSON(w, HistoryKeys())
+	}
+	records := History(name)
+	if records == nil {
+		records = []Record{}
+	}
+	return writeJSON(w, records)
+}
+
+func (a AdminAPI) handleRollback(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed(r)
+	}
+	name := r.URL.Query().Get("name")
+	revision, err := strconv.ParseInt(r.URL.Query().Get("revision"), 10, 64)
+	if name == "" || err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("name and revision(integer) are required"),
+		}
+	}
+	err = Rollback(name, revision)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("rollback %s to revision %d failed: %v", name, revision, err),
+		}
+	}
+	return writeJSON(w, History(name))
+}
+
+func (a AdminAPI) handleSchemas(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(r)
+	}
+	schemas, err := Schemas(r.Context())
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        err,
+		}
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		return writeJSON(w, schemas)
+	}
+	info, ok := schemas[name]
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("schema of %s not found", name),
+		}
+	}
+	return writeJSON(w, info)
+}
+
+func (a AdminAPI) handleThrottles(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(r)
+	}
+	return writeJSON(w, GetThrottleStats())
+}
+
+func (a AdminAPI) handleFreshness(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(r)
+	}
+	return writeJSON(w, Freshnesses())
+}
+
+func callbackInfo(r *http.Request, key string, cb Callback) CallbackInfo {
+	info := CallbackInfo{
+		Key:  key,
+		Type: fmt.Sprintf("%T", cb),
+	}
+	valuer, ok := cb.(Valuer)
+	if !ok {
+		return info
+	}
+	info.Name = valuer.ValueName()
+	value, err := valuer.CurrentValue(r.Context())
+	if err != nil {
+		info.ValueError = err.Error()
+	} else {
+		info.Value = redactSecrets(value, getDecrypted(info.Name))
+	}
+	if records := History(info.Name); len(records) > 0 {
+		info.Revision = records[0].Revision
+		info.UpdatedAt = &records[0].Time
+	}
+	return info
+}
+
+func methodNotAllowed(r *http.Request) error {
+	return caddy.APIError{
+		HTTPStatus: http.StatusMethodNotAllowed,
+		Err:        fmt.Errorf("method not allowed: %v", r.Method),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        err,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	return err
+}
+
+// Interface guard
+var (
+	_ caddy.AdminRouter = (*AdminAPI)(nil)
+)
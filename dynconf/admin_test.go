@@ -0,0 +1,134 @@
+package dynconf_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveAdmin(method, target, body string) (*httptest.ResponseRecorder, error) {
+	handler := dynconf.AdminAPI{}.Routes()[0].Handler
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	return w, handler.ServeHTTP(w, req)
+}
+
+func TestAdminAPI(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "admin:degrade", dynconf.NewRegCallback[bool]("admin_degrade"))
+	err := dynconf.Execute(ctx, []typemap.Ref[dynconf.Callback]{{Name: "admin:degrade"}}, "admin:degrade", `{"name": "admin_degrade", "value": false}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := serveAdmin(http.MethodGet, dynconf.AdminAPIPrefix+"callbacks", "")
+	assert.Nilf(t, err, "list callbacks")
+	var infos []dynconf.CallbackInfo
+	err = json.Unmarshal(w.Body.Bytes(), &infos)
+	assert.Nilf(t, err, "list callbacks")
+	var found bool
+	for _, info := range infos {
+		if info.Key == "admin:degrade" {
+			found = true
+			assert.Equalf(t, "admin_degrade", info.Name, "name")
+			assert.Equalf(t, false, info.Value, "value")
+			assert.NotNilf(t, info.UpdatedAt, "updated at")
+		}
+	}
+	assert.Truef(t, found, "list callbacks")
+
+	w, err = serveAdmin(http.MethodPost, dynconf.AdminAPIPrefix+"callback?key=admin:degrade", `{"name": "admin_degrade", "value": true}`)
+	assert.Nilf(t, err, "push value")
+	var info dynconf.CallbackInfo
+	err = json.Unmarshal(w.Body.Bytes(), &info)
+	assert.Nilf(t, err, "push value")
+	assert.Equalf(t, true, info.Value, "pushed value")
+	value, _ := typemap.Get[bool](ctx, "admin_degrade")
+	assert.Truef(t, value, "pushed value")
+	assert.Equalf(t, dynconf.AdminSourceKey, dynconf.History("admin_degrade")[0].SourceKey, "source key")
+
+	_, err = serveAdmin(http.MethodPost, dynconf.AdminAPIPrefix+"callback?key=admin:degrade", `{"name": "admin_degrade", "value": "yes"}`)
+	var apiErr caddy.APIError
+	assert.ErrorAsf(t, err, &apiErr, "invalid value")
+	assert.Equalf(t, http.StatusBadRequest, apiErr.HTTPStatus, "invalid value")
+
+	_, err = serveAdmin(http.MethodGet, dynconf.AdminAPIPrefix+"callback?key=admin:not_exists", "")
+	assert.ErrorAsf(t, err, &apiErr, "not found")
+	assert.Equalf(t, http.StatusNotFound, apiErr.HTTPStatus, "not found")
+
+	w, err = serveAdmin(http.MethodPost, dynconf.AdminAPIPrefix+"rollback?name=admin_degrade&revision=1", "")
+	assert.Nilf(t, err, "rollback")
+	value, _ = typemap.Get[bool](ctx, "admin_degrade")
+	assert.Falsef(t, value, "rollback value")
+
+	w, err = serveAdmin(http.MethodGet, dynconf.AdminAPIPrefix+"history?name=admin_degrade", "")
+	assert.Nilf(t, err, "history")
+	var records []dynconf.Record
+	err = json.Unmarshal(w.Body.Bytes(), &records)
+	assert.Nilf(t, err, "history")
+	assert.Lenf(t, records, 3, "history")
+}
+
+func TestAdminAPIRedact(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "admin:database", dynconf.NewRegCallback[Database]("admin_database"))
+	w, err := serveAdmin(http.MethodPost, dynconf.AdminAPIPrefix+"callback?key=admin:database", `{"name": "admin_database", "value": {"user": "root", "password": "p@ss"}}`)
+	assert.Nilf(t, err, "push value")
+	assert.NotContainsf(t, w.Body.String(), "p@ss", "pushed value redacted")
+	assert.Containsf(t, w.Body.String(), dynconf.RedactedValue, "pushed value redacted")
+
+	for _, target := range []string{"callbacks", "callback?key=admin:database", "history?name=admin_database"} {
+		w, err = serveAdmin(http.MethodGet, dynconf.AdminAPIPrefix+target, "")
+		assert.Nilf(t, err, target)
+		assert.NotContainsf(t, w.Body.String(), "p@ss", "%s redacted", target)
+	}
+	value, _ := typemap.Get[Database](ctx, "admin_database")
+	assert.Equalf(t, "p@ss", value.Password, "value not redacted")
+	record := dynconf.History("admin_database")[0]
+	assert.Equalf(t, map[string]any{"user": "root", "password": dynconf.RedactedValue}, record.Value, "history value redacted")
+	assert.Containsf(t, record.Data, "p@ss", "history data kept for rollback")
+}
+
+func TestAdminAPICallbackStatus(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "admin:status:invalid", dynconf.NewRegCallback[bool]("admin_status_invalid"))
+	typemap.MustRegister[dynconf.Callback](ctx, "admin:status:schema", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		return dynconf.DefaultValidate([]byte(`{"type": "boolean"}`), []byte(data))
+	}))
+	typemap.MustRegister[dynconf.Callback](ctx, "admin:status:timeout", dynconf.ContextCallbackFunc(func(ctx context.Context, sourceKey, data string) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	dynconf.SetCallbackTimeout("admin:status:timeout", 10*time.Millisecond)
+	defer dynconf.SetCallbackTimeout("admin:status:timeout", 0)
+	typemap.MustRegister[dynconf.Callback](ctx, "admin:status:failed", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		return errors.New("connection refused")
+	}))
+
+	cases := []struct {
+		key    string
+		body   string
+		status int
+	}{
+		{"admin:status:invalid", `{"name": "admin_status_invalid", "value": "yes"}`, http.StatusBadRequest},
+		{"admin:status:schema", `"yes"`, http.StatusBadRequest},
+		{"admin:status:timeout", `{}`, http.StatusGatewayTimeout},
+		{"admin:status:failed", `{}`, http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		_, err := serveAdmin(http.MethodPost, dynconf.AdminAPIPrefix+"callback?key="+c.key, c.body)
+		var apiErr caddy.APIError
+		assert.ErrorAsf(t, err, &apiErr, c.key)
+		assert.Equalf(t, c.status, apiErr.HTTPStatus, c.key)
+	}
+}
@@ -0,0 +1,185 @@
+package dynconf
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ccmonky/typemap"
+	"go.uber.org/multierr"
+)
+
+// TxCallback is a Callback which can be executed in two phases: Prepare validates and parses data without side effect,
+// and the returned commit applies it, used to update multiple keys transactionally, see `ExecuteBatch`, NOTE: ctx is
+// only used to carry the settings of the config executing the callback, e.g. strictness
+type TxCallback interface {
+	Callback
+	Prepare(ctx context.Context, sourceKey, data string) (commit func(ctx context.Context) error, err error)
+}
+
+// TxRestorer is a TxCallback which can restore the state replaced by commit, used to roll back the committed callbacks
+// of a batch if a later commit fails, see `ExecuteBatch`
+type TxRestorer interface {
+	TxCallback
+	// Save is called before commit, and returns a function restoring the current state
+	Save(ctx context.Context) (restore func(ctx context.Context) error, err error)
+}
+
+// BatchRollbackSourceKey is the sourceKey used when restoring the committed callbacks of a failed batch
+const BatchRollbackSourceKey = "rollback:batch"
+
+// Change is a new data of sourceKey delivered by listener, with the callbacks to execute
+type Change struct {
+	SourceKey string
+	Data      string
+	// Format is the format of Data, which is converted to json before executing callbacks, see `ToJSON`
+	Format    Format
+	Callbacks []typemap.Ref[Callback]
+}
+
+// ExecuteBatch executes callbacks of a set of changes transactionally: callbacks implementing TxCallback are all prepared
+// first, only if all succeed the changes are committed, otherwise nothing is changed and a single aggregated error is returned.
+// If a commit fails, the committed callbacks are restored in reverse order(see `TxRestorer`).
+// Callbacks not implementing TxCallback(e.g. CallbackFunc) are executed after committing.
+// NOTE: a batch of a single change whose callbacks are throttled(see `SetThrottle`) is executed like `Execute`, i.e.
+// delayed and coalesced, so that listeners delivering data in batches(e.g. config centers) are throttled as well;
+// otherwise throttles are bypassed within a batch, so that the batch is applied as a unit, and the delayed data of the
+// same sourceKey is discarded.
+// The changes are persisted as snapshots if all succeed, see `Snapshot`.
+func ExecuteBatch(ctx context.Context, changes []Change) error {
+	return executeBatch(ctx, changes, nil)
+}
+
+// executeBatch is like ExecuteBatch, applied(if not nil) is called with each change once the batch is applied, NOTE: it
+// is called asynchronously if the change is delayed by throttles, see `execute`
+func executeBatch(ctx context.Context, changes []Change, applied func(change Change)) error {
+	if len(changes) == 1 && throttled(ctx, changes[0].Callbacks) {
+		change := changes[0]
+		data, err := ToJSON(change.Format, change.Data)
+		if err != nil {
+			return fmt.Errorf("convert %s from %s failed: %v", change.SourceKey, change.Format, err)
+		}
+		ctx = WithSourceFormat(ctx, change.Format)
+		return execute(ctx, change.Callbacks, change.SourceKey, data, func() {
+			markApplied(ctx, change.SourceKey, data, change.Callbacks)
+			if applied != nil {
+				applied(change)
+			}
+		})
+	}
+	type pending struct {
+		ctx       context.Context // carries the format of the change, see `WithSourceFormat`
+		name      string
+		sourceKey string
+		data      string
+		cb        Callback
+		commit    func(ctx context.Context) error
+		restore   func(ctx context.Context) error
+	}
+	var pendings []pending
+	datas := make([]string, len(changes))
+	var errs error
+	// 1. prepare
+	for n, change := range changes {
+		data, err := ToJSON(change.Format, change.Data)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("convert %s from %s failed: %v", change.SourceKey, change.Format, err))
+			continue
+		}
+		datas[n] = data
+		changeCtx := WithSourceFormat(ctx, change.Format)
+		for i := range change.Callbacks {
+			ref := &change.Callbacks[i]
+			cb, err := ref.Value(ctx)
+			if err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("get callback %s failed: %v", ref.Name, err))
+				continue
+			}
+			p := pending{ctx: changeCtx, name: ref.Name, sourceKey: change.SourceKey, data: data, cb: cb}
+			if tx, ok := cb.(TxCallback); ok {
+				start := time.Now()
+				p.commit, err = tx.Prepare(changeCtx, change.SourceKey, data)
+				if err != nil {
+					observeCallback(ref.Name, start, err)
+					errs = multierr.Append(errs, fmt.Errorf("prepare callback %s with %s failed: %v", ref.Name, change.SourceKey, err))
+					continue
+				}
+			}
+			pendings = append(pendings, p)
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("batch of %d changes discarded: %v", len(changes), errs)
+	}
+	// 2. commit, and restore the committed ones if failed
+	var committed []pending
+	for _, p := range pendings {
+		if p.commit == nil {
+			continue
+		}
+		if r, ok := p.cb.(TxRestorer); ok {
+			restore, err := r.Save(ctx)
+			if err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("save callback %s failed: %v", p.name, err))
+				break
+			}
+			p.restore = restore
+		}
+		err := applyUnthrottled(p.ctx, p.name, p.sourceKey, p.commit)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("commit callback %s with %s failed: %v", p.name, p.sourceKey, err))
+			break
+		}
+		committed = append(committed, p)
+	}
+	if errs != nil {
+		for i := len(committed) - 1; i >= 0; i-- {
+			p := committed[i]
+			if p.restore == nil {
+				errs = multierr.Append(errs, fmt.Errorf("callback %s committed with %s can not be restored", p.name, p.sourceKey))
+				continue
+			}
+			// NOTE: restore even if ctx is done, e.g. the commit failed due to timeout
+			restoreCtx, cancel := context.WithTimeout(withSettings(context.Background(), settingsFrom(ctx)), CallbackTimeout(ctx, p.name))
+			err := p.restore(restoreCtx)
+			cancel()
+			if err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("restore callback %s failed: %v", p.name, err))
+			}
+		}
+		return fmt.Errorf("batch of %d changes rolled back: %v", len(changes), errs)
+	}
+	for _, p := range pendings {
+		if p.commit != nil {
+			continue
+		}
+		cb, sourceKey, data := p.cb, p.sourceKey, p.data
+		err := applyUnthrottled(p.ctx, p.name, sourceKey, func(ctx context.Context) error {
+			return WithContext(cb).CallbackContext(ctx, sourceKey, data)
+		})
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("execute callback %s with %s failed: %v", p.name, p.sourceKey, err))
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	for n, change := range changes {
+		markApplied(WithSourceFormat(ctx, change.Format), change.SourceKey, datas[n], change.Callbacks)
+		if applied != nil {
+			applied(change)
+		}
+	}
+	return nil
+}
+
+// throttled reports whether any of callbacks is throttled by the settings of ctx, see `SetThrottle`
+func throttled(ctx context.Context, callbacks []typemap.Ref[Callback]) bool {
+	s := settingsOf(ctx)
+	for i := range callbacks {
+		if _, ok := s.getThrottle(callbacks[i].Name); ok {
+			return true
+		}
+	}
+	return false
+}
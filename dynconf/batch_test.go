@@ -0,0 +1,140 @@
+package dynconf_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+type RateLimit struct {
+	Rate  int `json:"rate"`
+	Burst int `json:"burst"`
+}
+
+func TestExecuteBatch(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "batch:degrade", dynconf.NewRegCallback[bool]("batch_degrade"))
+	typemap.MustRegister[dynconf.Callback](ctx, "batch:rate_limit", dynconf.NewRegCallback[RateLimit]("batch_rate_limit"))
+	var executed []string
+	typemap.MustRegister[dynconf.Callback](ctx, "batch:record", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		executed = append(executed, sourceKey)
+		return nil
+	}))
+	typemap.MustSet(ctx, "batch_degrade", false)
+	typemap.MustSet(ctx, "batch_rate_limit", RateLimit{Rate: 100, Burst: 10})
+	changes := func(degrade, rateLimit string) []dynconf.Change {
+		return []dynconf.Change{
+			{
+				SourceKey: "degrade",
+				Data:      degrade,
+				Callbacks: []typemap.Ref[dynconf.Callback]{{Name: "batch:degrade"}, {Name: "batch:record"}},
+			},
+			{
+				SourceKey: "rate_limit",
+				Data:      rateLimit,
+				Callbacks: []typemap.Ref[dynconf.Callback]{{Name: "batch:rate_limit"}, {Name: "batch:record"}},
+			},
+		}
+	}
+
+	// 1. rate_limit invalid, nothing changed
+	err := dynconf.ExecuteBatch(ctx, changes(
+		`{"name": "batch_degrade", "value": true}`,
+		`{"name": "batch_rate_limit", "value": {"rate": "fast", "burst": 10}}`,
+	))
+	assert.NotNilf(t, err, "rate_limit invalid")
+	degrade, _ := typemap.Get[bool](ctx, "batch_degrade")
+	assert.Falsef(t, degrade, "degrade should not change")
+	rateLimit, _ := typemap.Get[RateLimit](ctx, "batch_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 100, Burst: 10}, rateLimit, "rate_limit should not change")
+	assert.Emptyf(t, executed, "non tx callbacks should not be executed")
+
+	// 2. both invalid, errors aggregated
+	err = dynconf.ExecuteBatch(ctx, changes(
+		`{"name": "xxx", "value": true}`,
+		`{"name": "batch_rate_limit", "value": {"rate": 1}}`,
+	))
+	assert.Containsf(t, err.Error(), "batch:degrade", "degrade error")
+	assert.Containsf(t, err.Error(), "batch:rate_limit", "rate_limit error")
+
+	// 3. all valid, all committed
+	err = dynconf.ExecuteBatch(ctx, changes(
+		`{"name": "batch_degrade", "value": true}`,
+		`{"name": "batch_rate_limit", "value": {"rate": 1, "burst": 1}}`,
+	))
+	assert.Nilf(t, err, "all valid")
+	degrade, _ = typemap.Get[bool](ctx, "batch_degrade")
+	assert.Truef(t, degrade, "degrade changed")
+	rateLimit, _ = typemap.Get[RateLimit](ctx, "batch_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 1, Burst: 1}, rateLimit, "rate_limit changed")
+	assert.Equalf(t, []string{"degrade", "rate_limit"}, executed, "non tx callbacks executed")
+}
+
+// failingTx is a TxCallback whose commit always fails
+type failingTx struct{}
+
+func (failingTx) Callback(sourceKey, data string) error {
+	return errors.New("commit failed")
+}
+
+func (failingTx) Prepare(ctx context.Context, sourceKey, data string) (func(ctx context.Context) error, error) {
+	return func(ctx context.Context) error {
+		return errors.New("commit failed")
+	}, nil
+}
+
+func TestExecuteBatchRollback(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "batch:rollback:degrade", dynconf.NewRegCallback[bool]("batch_rollback_degrade"))
+	typemap.MustRegister[dynconf.Callback](ctx, "batch:rollback:new", dynconf.NewRegCallback[string]("batch_rollback_new"))
+	typemap.MustRegister[dynconf.Callback](ctx, "batch:rollback:fail", failingTx{})
+	var executed []string
+	typemap.MustRegister[dynconf.Callback](ctx, "batch:rollback:record", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		executed = append(executed, sourceKey)
+		return nil
+	}))
+	typemap.MustSet(ctx, "batch_rollback_degrade", false)
+
+	err := dynconf.ExecuteBatch(ctx, []dynconf.Change{
+		{
+			SourceKey: "degrade",
+			Data:      `{"name": "batch_rollback_degrade", "value": true}`,
+			Callbacks: []typemap.Ref[dynconf.Callback]{{Name: "batch:rollback:degrade"}, {Name: "batch:rollback:record"}},
+		},
+		{
+			SourceKey: "new",
+			Data:      `{"name": "batch_rollback_new", "value": "new"}`,
+			Callbacks: []typemap.Ref[dynconf.Callback]{{Name: "batch:rollback:new"}},
+		},
+		{
+			SourceKey: "fail",
+			Data:      `{}`,
+			Callbacks: []typemap.Ref[dynconf.Callback]{{Name: "batch:rollback:fail"}},
+		},
+	})
+	assert.NotNilf(t, err, "second commit failed")
+	assert.Containsf(t, err.Error(), "commit failed", "commit error")
+	degrade, err := typemap.Get[bool](ctx, "batch_rollback_degrade")
+	assert.Nilf(t, err, "degrade restored")
+	assert.Falsef(t, degrade, "degrade restored")
+	_, err = typemap.Get[string](ctx, "batch_rollback_new")
+	assert.NotNilf(t, err, "new value deleted")
+	assert.Emptyf(t, executed, "non tx callbacks should not be executed")
+	history := dynconf.History("batch_rollback_degrade")
+	if assert.Lenf(t, history, 2, "committed and restored") {
+		assert.Equalf(t, dynconf.BatchRollbackSourceKey, history[0].SourceKey, "restored")
+		assert.JSONEqf(t, `{"name": "batch_rollback_degrade", "value": false}`, history[0].Data, "restored")
+	}
+
+	// the restored revision can be rolled back to
+	err = dynconf.Rollback("batch_rollback_degrade", history[1].Revision)
+	assert.Nilf(t, err, "rollback")
+	err = dynconf.Rollback("batch_rollback_degrade", history[0].Revision)
+	assert.Nilf(t, err, "rollback to restored")
+	degrade, _ = typemap.Get[bool](ctx, "batch_rollback_degrade")
+	assert.Falsef(t, degrade, "rollback to restored")
+}
@@ -1,15 +1,21 @@
 package dynconf
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/ccmonky/typemap"
+	"github.com/eko/gocache/lib/v4/store"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
 )
 
 func init() {
@@ -28,6 +34,9 @@ func init() {
 //     	   log.Println(sourceKey, data)
 //     	   return nil
 //     }))
+//     typemap.MustRegister[dynconf.Callback](ctx, "group:data_id:pool", dynconf.ContextCallbackFunc(func(ctx context.Context, sourceKey, data string) error {
+//     	   return pool.Rebuild(ctx, data)
+//     }))
 // }
 //
 // 2. configure callbacks(`config.ext.dynconf.callbacks`)
@@ -37,9 +46,11 @@ func init() {
 // 	   	   "ext": {
 // 	   	   	   "dynconf": {
 // 	   	   	   	   "callbacks": {
+// 	   	   	   	   	   "timeout": "3s",
 // 	   	   	   	   	   "defaults": [
 // 	   	   	   	   	   	   {
 // 	   	   	   	   	   	   	   "keys": ["group:data_id"],
+// 	   	   	   	   	   	   	   "timeout": "1s",
 // 	   	   	   	   	   	   	   "default": {
 // 	   	   	   	   	   	   	   	   "name": "degrade",
 // 	   	   	   	   	   	   	   	   "value": false
@@ -61,15 +72,24 @@ func init() {
 //                 "listeners": [
 //                     {
 //                         "listener": "nacos",
-//                         "server_config": "",
-//                         "client_config": "",
+//                         "server_configs": [
+//                             {
+//                                 "ip_addr": "127.0.0.1",
+//                                 "port": 8848
+//                             }
+//                         ],
+//                         "client_config": {
+//                             "namespace_id": "public"
+//                         },
 //                         "datas": [
-//                             "group": "group"
-//                             "data_id": "data_id",
-//                             "callbacks": [
-//                                 "group:data_id",
-//                                 "group:data_id:log"
-//                             ]
+//                             {
+//                                 "group": "group",
+//                                 "data_id": "data_id",
+//                                 "callbacks": [
+//                                     "group:data_id",
+//                                     "group:data_id:log"
+//                                 ]
+//                             }
 //                         ]
 //                     }
 //                 ]
@@ -79,12 +99,42 @@ func init() {
 // }
 type Callbacks struct {
 	Defaults []CallbackDefault `json:"defaults,omitempty"`
+	// HistorySize is the max number of history records kept for each RegCallback key, default is 10
+	HistorySize int `json:"history_size,omitempty"`
+	// Timeout is the default timeout of executing callbacks, default is 3s
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+	// Strictness is the default policy of unknown fields and duplicate keys in data of RegCallbacks, available values are:
+	// `strict`(default), `warn` and `lenient`
+	Strictness Strictness `json:"strictness,omitempty"`
+	// SnapshotDir is the directory to persist the last data of each sourceKey applied successfully, which are applied
+	// by listeners failed to fetch the initial data, default is empty which disables snapshots
+	SnapshotDir string `json:"snapshot_dir,omitempty"`
+	// SnapshotMaxAge is the max age of snapshots, the data from older snapshots is reported as stale until updated
+	// by listeners, default is no limit
+	SnapshotMaxAge caddy.Duration `json:"snapshot_max_age,omitempty"`
+
+	keys     KeyProvider // loaded by `Dynconf.Provision`, default is the one set by `SetKeyProvider`
+	settings *settings   // built by Provision
 }
 
 // CallbackDefault define the default config for specified keys
 type CallbackDefault struct {
 	Keys    []string        `json:"keys"`
-	Default json.RawMessage `json:"default"`
+	Default json.RawMessage `json:"default,omitempty"`
+	// Timeout is the timeout of executing callbacks of keys, overrides the timeout of listeners and the default timeout
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+	// Strictness is the policy of RegCallbacks of keys, overrides the default strictness
+	Strictness Strictness `json:"strictness,omitempty"`
+	// Schema is the json schema used to validate the value of RegCallbacks of keys, instead of the schema generated from type
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// Format is the format of data of RegCallbacks of keys, available values are: `json`(default), `yaml`, `toml` and `properties`
+	// NOTE: data already converted to json by the listener with its own format is not converted again
+	Format Format `json:"format,omitempty"`
+	// Raw enables the raw-value mode of RegCallbacks of keys, i.e. data(including `default`) is the bare value without
+	// the name/value envelope, NOTE: callbacks created by `NewRawRegCallback` are always in raw-value mode
+	Raw bool `json:"raw,omitempty"`
+	// Throttle is the debounce and rate limit policy of applying data to callbacks of keys, see `Throttle`
+	Throttle *Throttle `json:"throttle,omitempty"`
 }
 
 // ID caddy module id
@@ -100,15 +150,95 @@ func (cs Callbacks) CaddyModule() caddy.ModuleInfo {
 	}
 }
 
-// Provision implement caddy.Provisioner, execute callbacks with default config
+// Provision implement caddy.Provisioner, build the settings of this config and execute callbacks with default config
+//
+// NOTE: the settings are not global, they are used by the callbacks executed by the listeners of this config(see
+// `Dynconf.Provision`), based on the defaults set by code(e.g. `SetStrictness`)
 func (cs *Callbacks) Provision(ctx caddy.Context) error {
+	if err := cs.Strictness.Validate(); err != nil {
+		return err
+	}
+	if err := makeSnapshotDir(cs.SnapshotDir); err != nil {
+		return err
+	}
+	s := getDefaults().clone()
+	s.logger = ctx.Logger(cs)
+	s.historySize = DefaultHistorySize
+	if cs.HistorySize > 0 {
+		s.historySize = cs.HistorySize
+	}
+	s.timeout = DefaultCallbackTimeout
+	if cs.Timeout > 0 {
+		s.timeout = time.Duration(cs.Timeout)
+	}
+	s.strictness = Strict
+	if cs.Strictness != "" {
+		s.strictness = cs.Strictness
+	}
+	s.snapshotDir, s.snapshotMaxAge = cs.SnapshotDir, time.Duration(cs.SnapshotMaxAge)
+	if cs.keys != nil {
+		s.keyProvider = cs.keys
+	}
 	for _, def := range cs.Defaults {
+		if err := def.Strictness.Validate(); err != nil {
+			return err
+		}
+		if err := def.Format.Validate(); err != nil {
+			return err
+		}
+		var o override
+		if len(def.Schema) > 0 {
+			var err error
+			o, err = newOverride(fmt.Sprint(def.Keys), def.Schema)
+			if err != nil {
+				return err
+			}
+		}
 		for _, key := range def.Keys {
+			if def.Timeout > 0 {
+				s.timeouts[key] = time.Duration(def.Timeout)
+			}
+			if def.Throttle != nil && !def.Throttle.IsZero() {
+				s.throttles[key] = *def.Throttle
+			}
+			if def.Strictness == "" && len(def.Schema) == 0 && def.Format == "" && !def.Raw {
+				continue
+			}
 			cb, err := typemap.Get[Callback](ctx, key)
 			if err != nil {
 				return fmt.Errorf("get callback %s failed: %v", key, err)
 			}
-			err = cb.Callback(key, string(def.Default))
+			valuer, ok := cb.(Valuer)
+			if !ok {
+				return fmt.Errorf("callback %s(%T) does not support strictness, schema, format and raw", key, cb)
+			}
+			name := valuer.ValueName()
+			if def.Strictness != "" {
+				s.strictnesses[name] = def.Strictness
+			}
+			if def.Format != "" {
+				s.formats[name] = def.Format
+			}
+			if def.Raw {
+				s.raws[name] = true
+			}
+			if len(def.Schema) > 0 {
+				s.overrides[name] = o
+			}
+		}
+	}
+	cs.settings = s
+	execCtx := withSettings(ctx, s)
+	for _, def := range cs.Defaults {
+		if len(def.Default) == 0 {
+			continue
+		}
+		for _, key := range def.Keys {
+			cb, err := typemap.Get[Callback](ctx, key)
+			if err != nil {
+				return fmt.Errorf("get callback %s failed: %v", key, err)
+			}
+			err = callCallback(execCtx, key, cb, key, string(def.Default), nil)
 			if err != nil {
 				return fmt.Errorf("execute callback %s with default value failed: %v", key, err)
 			}
@@ -122,6 +252,45 @@ type Callback interface {
 	Callback(sourceKey, data string) error
 }
 
+// ContextCallback is a Callback which accepts a context, listeners pass a context which is canceled when they are cleaned up
+// and has a deadline of `CallbackTimeout`
+type ContextCallback interface {
+	Callback
+	CallbackContext(ctx context.Context, sourceKey, data string) error
+}
+
+// WithContext adapts a Callback to ContextCallback, if cb does not implement ContextCallback, the context is only checked
+// before executing it
+func WithContext(cb Callback) ContextCallback {
+	if ccb, ok := cb.(ContextCallback); ok {
+		return ccb
+	}
+	return contextCallback{cb: cb}
+}
+
+type contextCallback struct {
+	cb Callback
+}
+
+func (c contextCallback) Callback(sourceKey, data string) error {
+	return c.cb.Callback(sourceKey, data)
+}
+
+func (c contextCallback) CallbackContext(ctx context.Context, sourceKey, data string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.cb.Callback(sourceKey, data)
+}
+
+// Valuer is implemented by callbacks which inject value into typemap, e.g. RegCallback
+type Valuer interface {
+	// ValueName returns the typemap instance name
+	ValueName() string
+	// CurrentValue returns the current value in typemap
+	CurrentValue(ctx context.Context) (any, error)
+}
+
 // CallbackFunc callback function
 type CallbackFunc func(sourceKey, data string) error
 
@@ -129,6 +298,49 @@ func (cf CallbackFunc) Callback(sourceKey, data string) error {
 	return cf(sourceKey, data)
 }
 
+// ContextCallbackFunc context-aware callback function
+type ContextCallbackFunc func(ctx context.Context, sourceKey, data string) error
+
+// Callback executes the function with the default timeout
+func (cf ContextCallbackFunc) Callback(sourceKey, data string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCallbackTimeout())
+	defer cancel()
+	return cf(ctx, sourceKey, data)
+}
+
+func (cf ContextCallbackFunc) CallbackContext(ctx context.Context, sourceKey, data string) error {
+	return cf(ctx, sourceKey, data)
+}
+
+// Execute executes all callbacks with sourceKey and data, each callback is executed with the timeout of `CallbackTimeout`,
+// a failed callback does not prevent the rest from executing, all errors are combined into the returned error,
+// and the data is persisted as snapshot if all succeed(after applied if delayed by throttles), see `Snapshot`
+func Execute(ctx context.Context, callbacks []typemap.Ref[Callback], sourceKey, data string) error {
+	return execute(ctx, callbacks, sourceKey, data, func() {
+		markApplied(ctx, sourceKey, data, callbacks)
+	})
+}
+
+// execute executes all callbacks, applied(if not nil) is called once all callbacks succeed, NOTE: it is called
+// asynchronously after the data delayed by throttles is applied, see `Throttle`
+func execute(ctx context.Context, callbacks []typemap.Ref[Callback], sourceKey, data string, applied func()) error {
+	pending := newPendingApplies(applied)
+	var errs error
+	for i := range callbacks {
+		cb, err := callbacks[i].Value(ctx)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("get callback %s failed: %v", callbacks[i].Name, err))
+			continue
+		}
+		err = callCallback(ctx, callbacks[i].Name, cb, sourceKey, data, pending)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("execute callback %s failed: %v", callbacks[i].Name, err))
+		}
+	}
+	pending.done(errs)
+	return errs
+}
+
 // NewRegCallback create a new RegCallback instance
 func NewRegCallback[T any](key string) Callback {
 	return &RegCallback[T]{
@@ -136,59 +348,319 @@ func NewRegCallback[T any](key string) Callback {
 	}
 }
 
-// RegCallback typemap.Reg[T] as a callback
+// RegCallback typemap.Reg[T] as a callback, data is `{"name": ..., "value": ...}`, or the bare value in raw-value mode, see `SetRaw`
 type RegCallback[T any] typemap.Reg[T]
 
+// Callback executes the callback with the default timeout
 func (r RegCallback[T]) Callback(sourceKey, data string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCallbackTimeout())
 	defer cancel()
-	// 1. log
-	var typeNotFound bool
-	value, err := typemap.Get[T](ctx, r.Name)
+	return r.CallbackContext(ctx, sourceKey, data)
+}
+
+// CallbackContext implement ContextCallback
+func (r RegCallback[T]) CallbackContext(ctx context.Context, sourceKey, data string) error {
+	commit, err := r.Prepare(ctx, sourceKey, data)
 	if err != nil {
-		if typemap.IsNotFound(err) {
-			typeNotFound = true
-			log.Printf("got data %s:%s first time\n", sourceKey, data) // TODO: inject logger
-		} else {
-			return err
+		return err
+	}
+	return commit(ctx)
+}
+
+// Prepare implement TxCallback, validate and parse data, the returned commit injects the value into typemap
+func (r RegCallback[T]) Prepare(ctx context.Context, sourceKey, data string) (func(ctx context.Context) error, error) {
+	return r.prepare(ctx, settingsOf(ctx).raws[r.Name], sourceKey, data)
+}
+
+func (r RegCallback[T]) prepare(ctx context.Context, raw bool, sourceKey, data string) (func(ctx context.Context) error, error) {
+	start := time.Now()
+	s := settingsOf(ctx)
+	parsed, err := r.parse(s, sourceFormat(ctx), raw, sourceKey, data)
+	if err != nil {
+		if IsValidationError(err) {
+			dynconfMetrics.validationFailures.WithLabelValues(r.Name).Inc()
 		}
-	} else {
-		oldData, err := json.Marshal(value)
+		s.getLogger().Error("invalid data",
+			zap.String("source_key", sourceKey),
+			zap.String("name", r.Name),
+			zap.Duration("duration", time.Since(start)),
+			zap.String("outcome", "invalid"),
+			zap.Error(err))
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		return r.commit(ctx, raw, start, sourceKey, data, parsed)
+	}, nil
+}
+
+// isJSONString reports whether data is a json string literal, e.g. `"info"`
+func isJSONString(data string) bool {
+	var s string
+	return strings.HasPrefix(strings.TrimSpace(data), `"`) && json.Unmarshal([]byte(data), &s) == nil
+}
+
+// parse converts data to json according to the format, decrypts secrets, then validates data and reports all issues
+// at once according to the strictness: syntax errors, secrets, duplicate keys, unknown fields and json schema violations,
+// the format, KeyProvider, strictness and schema come from s, and source is the format data was converted from by the
+// listener, see `WithSourceFormat`
+//
+// NOTE: data which is already json is not converted again, e.g. data converted by the listener with its own format,
+// snapshots and history records, so the format of listener and callback can be mixed
+func (r RegCallback[T]) parse(s *settings, source Format, raw bool, sourceKey, data string) (*regValue[T], error) {
+	// 0. convert to json, NOTE: in raw-value mode, data which is not a json string literal is the bare value if T is a
+	// string, e.g. `info`, `123`, `true` and `null`
+	if raw && s.getFormat(r.Name) == FormatJSON && reflect.TypeOf((*T)(nil)).Elem().Kind() == reflect.String {
+		if !isJSONString(data) {
+			quoted, _ := json.Marshal(data)
+			data = string(quoted)
+		}
+	} else if !json.Valid([]byte(data)) {
+		source = s.getFormat(r.Name)
+		converted, err := ToJSON(source, data)
 		if err != nil {
-			return err
+			return nil, &ValidationError{Name: r.Name, Issues: []Issue{{Message: err.Error()}}}
 		}
-		log.Printf("change data %s from %s to %s\n", sourceKey, string(oldData), data) // TODO: inject logger
+		data = converted
 	}
-	// 2. validate
-	// 2.1 validate typemap instance key
-	tmp := make(map[string]any)
-	err = json.Unmarshal([]byte(data), &tmp) // FIXME: strict mode?
+	// 1. decode and detect duplicate keys
+	value, duplicates, err := decodeJSON([]byte(data))
 	if err != nil {
-		return err
+		return nil, &ValidationError{Name: r.Name, Issues: []Issue{{Message: err.Error()}}}
+	}
+	// NOTE: in raw-value mode, data is wrapped into the envelope, and issues reference the bare value
+	if raw {
+		value = map[string]any{"name": r.Name, "value": value}
+		for i := range duplicates {
+			duplicates[i].Path = "/value" + duplicates[i].Path
+		}
+	}
+	object, ok := value.(map[string]any)
+	if !ok {
+		return nil, &ValidationError{Name: r.Name, Issues: []Issue{{Message: "data should be an object"}}}
+	}
+	// 2. decrypt secrets, see `SecretPrefix`
+	var issues []Issue
+	var secrets []string
+	if p := s.keyProvider; p != nil {
+		decryptSecrets(p, object, "", &secrets, &issues)
+		sortIssues(issues)
 	}
-	if tmp["name"] != r.Name {
-		return fmt.Errorf("name %s not equals to default name %s", tmp["name"], r.Name)
+	// 3. detect and prune unknown fields, then the schema only reports violations of known fields, and convert the
+	// string values of properties to the types of fields, see `FormatProperties`
+	var unknowns []Issue
+	pruneUnknown(reflect.TypeOf(regValue[T]{}), object, "", &unknowns)
+	if source == FormatProperties {
+		coerceStrings(reflect.TypeOf(regValue[T]{}), object)
 	}
-	// 2.2 validate with schema(all fields should be present)
-	err = validator.Validate(&r, []byte(data))
+	sortIssues(duplicates)
+	sortIssues(unknowns)
+	switch strictness := s.getStrictness(r.Name); strictness {
+	case Strict:
+		issues = append(issues, duplicates...)
+		issues = append(issues, unknowns...)
+	case WarnOnUnknown:
+		if len(duplicates) > 0 || len(unknowns) > 0 {
+			s.getLogger().Warn("duplicate keys or unknown fields ignored",
+				zap.String("source_key", sourceKey),
+				zap.String("name", r.Name),
+				zap.Any("issues", rawIssues(raw, append(duplicates, unknowns...))))
+		}
+	}
+	// 4. validate typemap instance name and extract rollout(see `Rollout`)
+	if object["name"] != r.Name {
+		issues = append(issues, Issue{Path: "/name", Message: fmt.Sprintf("name %v not equals to default name %s", object["name"], r.Name)})
+	}
+	rollout, rolloutIssues := extractRollout(object)
+	issues = append(issues, rolloutIssues...)
+	// 5. validate with schema(all fields should be present), or the schema set by `SetSchema`
+	pruned, err := json.Marshal(object)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// 3. parse data and inject into typemap
-	reg := new(typemap.Reg[T])
-	if typeNotFound {
-		reg.Action = typemap.RegisterAction
+	overridden, err := s.validateOverride(r.Name, object)
+	if !overridden {
+		err = validator.Validate(&r, pruned)
 	}
-	decoder := json.NewDecoder(strings.NewReader(data))
+	var schemaErr *SchemaError
+	switch {
+	case errors.As(err, &schemaErr):
+		issues = append(issues, schemaErr.Issues...)
+	case err != nil:
+		issues = append(issues, Issue{Message: err.Error()})
+	}
+	if len(issues) > 0 {
+		return nil, &ValidationError{Name: r.Name, Issues: rawIssues(raw, issues)}
+	}
+	// 6. parse data
+	// NOTE: not decode into typemap.Reg[T] which will inject the value into typemap while unmarshaling
+	parsed := new(regValue[T])
+	decoder := json.NewDecoder(bytes.NewReader(pruned))
 	decoder.DisallowUnknownFields()
-	err = decoder.Decode(reg)
+	err = decoder.Decode(parsed)
 	if err != nil {
+		return nil, &ValidationError{Name: r.Name, Issues: []Issue{{Message: err.Error()}}}
+	}
+	parsed.Rollout = rollout
+	for _, path := range secrets {
+		if path == "/value" || strings.HasPrefix(path, "/value/") {
+			parsed.secrets = append(parsed.secrets, strings.TrimPrefix(path, "/value"))
+		}
+	}
+	sort.Strings(parsed.secrets)
+	return parsed, nil
+}
+
+func (r RegCallback[T]) commit(ctx context.Context, raw bool, start time.Time, sourceKey, data string, parsed *regValue[T]) error {
+	s := settingsOf(ctx)
+	logger := s.getLogger().With(zap.String("source_key", sourceKey), zap.String("name", r.Name))
+	// 0. skip if this instance is not selected by rollout
+	if parsed.Rollout != nil && !parsed.Rollout.Selected(r.Name, InstanceID()) {
+		logger.Info("value skipped by rollout",
+			zap.String("instance_id", InstanceID()),
+			zap.Duration("duration", time.Since(start)),
+			zap.String("outcome", "skipped"))
+		return nil
+	}
+	// 1. get old value
+	action := typemap.SetAction
+	old, err := typemap.Get[T](ctx, r.Name)
+	if err != nil {
+		if !isNotFound(err) {
+			logger.Error("get old value failed", zap.Duration("duration", time.Since(start)), zap.String("outcome", "failed"), zap.Error(err))
+			return err
+		}
+		action = typemap.RegisterAction
+	}
+	oldFound := err == nil
+	// 2. inject into typemap
+	if parsed.Action == typemap.RegisterAction || parsed.Action == typemap.SetAction {
+		action = parsed.Action
+	}
+	switch action {
+	case typemap.RegisterAction:
+		err = typemap.Register(ctx, r.Name, parsed.Value)
+	default:
+		err = typemap.Set(ctx, r.Name, parsed.Value)
+	}
+	if err != nil {
+		err = fmt.Errorf("%s Reg[%T] failed: %v", action, *new(T), err)
+		logger.Error("apply value failed", zap.Duration("duration", time.Since(start)), zap.String("outcome", "failed"), zap.Error(err))
 		return err
 	}
+	// 3. record history and log, NOTE: the decrypted secrets are redacted
+	oldSecrets := getDecrypted(r.Name)
+	setDecrypted(r.Name, parsed.secrets)
+	redacted := redactSecrets(parsed.Value, parsed.secrets)
+	var fields []zap.Field
+	var ops []Operation
+	if oldFound {
+		ops, err = Diff(redactSecrets(old, oldSecrets), redacted)
+		if err != nil {
+			logger.Warn("diff value failed", zap.Error(err))
+		}
+		fields = append(fields, zap.Any("diff", ops))
+	} else {
+		fields = append(fields, zap.Any("new", redacted))
+	}
+	// NOTE: the history is rolled back with the callback in the same mode
+	var callback Callback = r
+	if raw {
+		callback = RawRegCallback[T]{RegCallback: r}
+	}
+	rec := record(s.historySize, r.Name, callback, sourceKey, data, sourceFormat(ctx), redacted, ops)
+	fields = append(fields,
+		zap.Int64("revision", rec.Revision),
+		zap.Duration("duration", time.Since(start)),
+		zap.String("outcome", string(action)))
+	logger.Info("value applied", fields...)
+	// 4. notify subscribers
+	publish(Event[T]{
+		Name:      r.Name,
+		SourceKey: sourceKey,
+		Revision:  rec.Revision,
+		OldFound:  oldFound,
+		Old:       old,
+		New:       parsed.Value,
+	})
 	return nil
 }
 
+// Save implement TxRestorer, the returned function restores the current value as an update with sourceKey
+// `rollback:batch`, or deletes the value if not found
+func (r RegCallback[T]) Save(ctx context.Context) (func(ctx context.Context) error, error) {
+	return r.save(ctx, settingsOf(ctx).raws[r.Name])
+}
+
+func (r RegCallback[T]) save(ctx context.Context, raw bool) (func(ctx context.Context) error, error) {
+	old, err := typemap.Get[T](ctx, r.Name)
+	if err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+		return func(ctx context.Context) error {
+			err := typemap.Delete[T](ctx, r.Name)
+			if err != nil {
+				return fmt.Errorf("delete Reg[%T] failed: %v", *new(T), err)
+			}
+			settingsOf(ctx).getLogger().Info("value deleted", zap.String("source_key", BatchRollbackSourceKey), zap.String("name", r.Name))
+			return nil
+		}, nil
+	}
+	// NOTE: data is recorded in history, so that it can be rolled back to, and the data of the current revision is
+	// reused if the value has decrypted secrets, so that the plaintext is never recorded
+	secrets := getDecrypted(r.Name)
+	var data []byte
+	var format Format
+	switch records := History(r.Name); {
+	case len(secrets) > 0 && len(records) > 0:
+		data, format = []byte(records[0].Data), records[0].format
+	case raw:
+		data, err = json.Marshal(old)
+	default:
+		data, err = json.Marshal(regValue[T]{Name: r.Name, Value: old})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		parsed := &regValue[T]{Name: r.Name, Value: old, Action: typemap.SetAction, secrets: secrets}
+		return r.commit(WithSourceFormat(ctx, format), raw, time.Now(), BatchRollbackSourceKey, string(data), parsed)
+	}, nil
+}
+
+// ValueName implement Valuer, returns the typemap instance name
+func (r RegCallback[T]) ValueName() string {
+	return r.Name
+}
+
+// CurrentValue implement Valuer, returns the current value in typemap
+func (r RegCallback[T]) CurrentValue(ctx context.Context) (any, error) {
+	return typemap.Get[T](ctx, r.Name)
+}
+
+// isNotFound reports whether err means T or the instance of T not found in typemap
+func isNotFound(err error) bool {
+	return typemap.IsNotFound(err) || errors.Is(err, store.NotFound{})
+}
+
+// regValue has the same json shape with typemap.Reg[T], but has no side effect while unmarshaling
+type regValue[T any] struct {
+	Name   string         `json:"name"`
+	Value  T              `json:"value"`
+	Action typemap.Action `json:"action,omitempty"`
+	// Rollout is not part of typemap.Reg[T], it is extracted before validating with schema
+	Rollout *Rollout `json:"rollout,omitempty"`
+
+	// secrets are the json pointers of the decrypted secrets in value
+	secrets []string
+}
+
 // Interface guard
 var (
 	_ caddy.Provisioner = (*Callbacks)(nil)
+	_ TxCallback        = (*RegCallback[any])(nil)
+	_ TxRestorer        = (*RegCallback[any])(nil)
+	_ ContextCallback   = (*RegCallback[any])(nil)
+	_ ContextCallback   = ContextCallbackFunc(nil)
+	_ Valuer            = (*RegCallback[any])(nil)
 )
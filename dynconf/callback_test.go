@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"log"
 	"testing"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/ccmonky/caddy-config/dynconf"
 	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCallback(t *testing.T) {
@@ -74,3 +76,140 @@ func TestCallback(t *testing.T) {
 		t.Fatal("should == false")
 	}
 }
+
+func TestCallbacksReload(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "reload:rate_limit", dynconf.NewRegCallback[RateLimit]("reload_rate_limit"))
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	provision := func(data string) error {
+		callbacks := &dynconf.Callbacks{}
+		err := json.Unmarshal([]byte(data), callbacks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return callbacks.Provision(caddyCtx)
+	}
+	err := provision(`{
+		"defaults": [
+			{
+				"keys": ["reload:rate_limit"],
+				"timeout": "20s",
+				"strictness": "lenient",
+				"format": "yaml",
+				"raw": true,
+				"throttle": {"debounce": "1s"},
+				"default": {"rate": 1, "burst": 2, "unknown": 3}
+			}
+		]
+	}`)
+	assert.Nilf(t, err, "provision")
+	value, err := typemap.Get[RateLimit](ctx, "reload_rate_limit")
+	assert.Nilf(t, err, "default applied with the settings of config")
+	assert.Equalf(t, RateLimit{Rate: 1, Burst: 2}, value, "default applied with the settings of config")
+	assert.Equalf(t, dynconf.DefaultCallbackTimeout, dynconf.CallbackTimeout(ctx, "reload:rate_limit"), "timeout not global")
+	assert.Equalf(t, dynconf.Strict, dynconf.GetStrictness("reload_rate_limit"), "strictness not global")
+	assert.Equalf(t, dynconf.FormatJSON, dynconf.GetFormat("reload_rate_limit"), "format not global")
+	assert.Falsef(t, dynconf.IsRaw("reload_rate_limit"), "raw not global")
+	_, ok := dynconf.GetThrottle("reload:rate_limit")
+	assert.Falsef(t, ok, "throttle not global")
+
+	err = provision(`{"defaults": [{"keys": ["reload:rate_limit"], "strictness": "invalid"}]}`)
+	assert.NotNilf(t, err, "invalid strictness")
+	err = provision(`{"defaults": [{"keys": ["reload:rate_limit"], "strictness": "warn", "schema": {"type": 1}}]}`)
+	assert.NotNilf(t, err, "invalid schema")
+	err = provision(`{
+		"defaults": [
+			{
+				"keys": ["reload:rate_limit"],
+				"default": {"name": "reload_rate_limit", "value": {"rate": "fast"}}
+			}
+		]
+	}`)
+	assert.NotNilf(t, err, "invalid default")
+	value, err = typemap.Get[RateLimit](ctx, "reload_rate_limit")
+	assert.Nilf(t, err, "value kept if provision failed")
+	assert.Equalf(t, RateLimit{Rate: 1, Burst: 2}, value, "value kept if provision failed")
+}
+
+// reloadListener is a listener recording the instances provisioned by name
+type reloadListener struct {
+	dynconf.ListenerOptions
+	Name string `json:"name"`
+}
+
+var reloadListeners = make(map[string]*reloadListener)
+
+func init() {
+	caddy.RegisterModule(reloadListener{})
+}
+
+func (reloadListener) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "config.ext.dynconf.listeners.reload",
+		New: func() caddy.Module { return new(reloadListener) },
+	}
+}
+
+func (l *reloadListener) Provision(ctx caddy.Context) error {
+	reloadListeners[l.Name] = l
+	return nil
+}
+
+func TestDynconfReload(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "dynconf:reload", dynconf.NewRegCallback[RateLimit]("dynconf_reload"))
+	callbacks := []typemap.Ref[dynconf.Callback]{{Name: "dynconf:reload"}}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	provision := func(data string) (*dynconf.Dynconf, error) {
+		d := &dynconf.Dynconf{}
+		err := json.Unmarshal([]byte(data), d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d, d.Provision(caddyCtx)
+	}
+	timeout := func() time.Duration {
+		return dynconf.CallbackTimeout(ctx, "dynconf:reload")
+	}
+	failed, err := provision(`{"callbacks": {"timeout": "5s"}, "listeners": [{"listener": "not_exists"}]}`)
+	assert.NotNilf(t, err, "invalid listener")
+	assert.Nilf(t, failed.Cleanup(), "cleanup failed config")
+	assert.Equalf(t, dynconf.DefaultCallbackTimeout, timeout(), "settings of failed config not used")
+
+	first, err := provision(`{
+		"callbacks": {
+			"timeout": "5s",
+			"defaults": [{"keys": ["dynconf:reload"], "format": "yaml", "raw": true}]
+		},
+		"listeners": [{"listener": "reload", "name": "first"}]
+	}`)
+	assert.Nilf(t, err, "first")
+	assert.Equalf(t, 5*time.Second, timeout(), "first")
+	err = reloadListeners["first"].Execute(ctx, callbacks, "reload", "rate: 1\nburst: 1")
+	assert.Nilf(t, err, "executed with the settings of first")
+
+	rejected, err := provision(`{"callbacks": {"timeout": "6s"}, "listeners": [{"listener": "reload", "name": "rejected"}]}`)
+	assert.Nilf(t, err, "rejected")
+	assert.Equalf(t, 6*time.Second, timeout(), "rejected")
+	assert.Equalf(t, 5*time.Second, dynconf.CallbackTimeout(reloadListeners["first"].CallbackContext(ctx), "dynconf:reload"),
+		"settings of first not changed by rejected")
+	err = reloadListeners["first"].Execute(ctx, callbacks, "reload", "rate: 2\nburst: 2")
+	assert.Nilf(t, err, "settings of first not changed by rejected")
+	err = reloadListeners["rejected"].Execute(ctx, callbacks, "reload", "rate: 3\nburst: 3")
+	assert.NotNilf(t, err, "executed with the settings of rejected")
+	assert.Nilf(t, rejected.Cleanup(), "config rejected")
+	assert.Equalf(t, 5*time.Second, timeout(), "settings of first used after rejected")
+	value, err := typemap.Get[RateLimit](ctx, "dynconf_reload")
+	assert.Nilf(t, err, "value")
+	assert.Equalf(t, RateLimit{Rate: 2, Burst: 2}, value, "value")
+
+	second, err := provision(`{"callbacks": {"timeout": "7s"}}`)
+	assert.Nilf(t, err, "second")
+	assert.Nilf(t, first.Cleanup(), "config reloaded")
+	assert.Equalf(t, 7*time.Second, timeout(), "settings of second used after reloaded")
+	assert.Nilf(t, second.Cleanup(), "config stopped")
+	assert.Nilf(t, second.Cleanup(), "cleanup again")
+	assert.Equalf(t, dynconf.DefaultCallbackTimeout, timeout(), "defaults used after stopped")
+}
@@ -0,0 +1,109 @@
+package dynconf
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+)
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "dynconf-schema",
+		Func:  cmdSchema,
+		Usage: "[--name <name>] [--output <file>]",
+		Short: "Exports the json schemas of dynconf RegCallbacks",
+		Long: `
+Exports the json schemas used to validate data of RegCallbacks registered in
+this binary, keyed by typemap instance name, so that config center can validate
+data before publishing.
+
+Only callbacks registered during init are exported, and schemas set by config
+are not included, use the admin endpoint GET /config-ext/dynconf/schemas of a
+running instance to get the schemas it enforces.`,
+		Flags: func() *flag.FlagSet {
+			fs := flag.NewFlagSet("dynconf-schema", flag.ExitOnError)
+			fs.String("name", "", "Typemap instance name of RegCallback, default is all")
+			fs.String("output", "", "The file to write, default is stdout")
+			return fs
+		}(),
+	})
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "dynconf-encrypt",
+		Func:  cmdEncrypt,
+		Usage: "--key-file <file> [--key-id <id>] < plaintext",
+		Short: "Encrypts a secret for dynconf data",
+		Long: `
+Encrypts the plaintext read from stdin with AES-GCM by the base64 encoded key
+in the key file, and prints the secret in the form of enc:[<key id>:]<base64>,
+which can be used as a string value of data in config center, and is decrypted
+by the dynconf keys provider with the same key id.`,
+		Flags: func() *flag.FlagSet {
+			fs := flag.NewFlagSet("dynconf-encrypt", flag.ExitOnError)
+			fs.String("key-file", "", "The file containing the base64 encoded key")
+			fs.String("key-id", "", "The key id, default is empty which means the default key")
+			return fs
+		}(),
+	})
+}
+
+func cmdSchema(fl caddycmd.Flags) (int, error) {
+	schemas, err := Schemas(context.Background())
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	var v any = schemas
+	if name := fl.String("name"); name != "" {
+		info, ok := schemas[name]
+		if !ok {
+			return caddy.ExitCodeFailedStartup, fmt.Errorf("schema of %s not found", name)
+		}
+		v = info
+	}
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	data = append(data, '\n')
+	if output := fl.String("output"); output != "" {
+		err = os.WriteFile(output, data, 0644)
+	} else {
+		_, err = os.Stdout.Write(data)
+	}
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+func cmdEncrypt(fl caddycmd.Flags) (int, error) {
+	keyFile := fl.String("key-file")
+	if keyFile == "" {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("--key-file is required")
+	}
+	encoded, err := os.ReadFile(keyFile)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("decode key failed: %v", err)
+	}
+	plaintext, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	secret, err := Encrypt(key, fl.String("key-id"), strings.TrimSuffix(string(plaintext), "\n"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	fmt.Println(secret)
+	return caddy.ExitCodeSuccess, nil
+}
@@ -0,0 +1,114 @@
+package dynconf
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operation is a field-level change between two values, in the form of json patch(RFC 6902) operation,
+// with the old value of `remove` and `replace` operations
+type Operation struct {
+	// Op is one of `add`, `remove` and `replace`
+	Op string `json:"op"`
+	// Path is a json pointer(RFC 6901), empty means the whole value
+	Path string `json:"path"`
+	// Value is the new value, NOTE: not omitted if empty, e.g. a field set to `0`, `false` or `""`
+	Value any `json:"value"`
+	Old   any `json:"old,omitempty"`
+}
+
+// Diff returns the operations which change old to new, both values are compared in the form of json value,
+// e.g. `Diff(Redact(old), Redact(new))` returns the diff for logging
+func Diff(old, new any) ([]Operation, error) {
+	oldValue, err := jsonValue(old)
+	if err != nil {
+		return nil, err
+	}
+	newValue, err := jsonValue(new)
+	if err != nil {
+		return nil, err
+	}
+	var ops []Operation
+	diff("", oldValue, newValue, &ops)
+	return ops, nil
+}
+
+func jsonValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	err = json.Unmarshal(data, &value)
+	return value, err
+}
+
+func diff(path string, old, new any, ops *[]Operation) {
+	switch oldValue := old.(type) {
+	case map[string]any:
+		if newValue, ok := new.(map[string]any); ok {
+			diffObject(path, oldValue, newValue, ops)
+			return
+		}
+	case []any:
+		if newValue, ok := new.([]any); ok {
+			diffArray(path, oldValue, newValue, ops)
+			return
+		}
+	}
+	if !reflect.DeepEqual(old, new) {
+		*ops = append(*ops, Operation{Op: "replace", Path: path, Value: new, Old: old})
+	}
+}
+
+func diffObject(path string, old, new map[string]any, ops *[]Operation) {
+	keys := make([]string, 0, len(old)+len(new))
+	for key := range old {
+		keys = append(keys, key)
+	}
+	for key := range new {
+		if _, ok := old[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		keyPath := path + "/" + escapePointer(key)
+		oldValue, inOld := old[key]
+		newValue, inNew := new[key]
+		switch {
+		case !inNew:
+			*ops = append(*ops, Operation{Op: "remove", Path: keyPath, Old: oldValue})
+		case !inOld:
+			*ops = append(*ops, Operation{Op: "add", Path: keyPath, Value: newValue})
+		default:
+			diff(keyPath, oldValue, newValue, ops)
+		}
+	}
+}
+
+func diffArray(path string, old, new []any, ops *[]Operation) {
+	n := len(old)
+	if len(new) < n {
+		n = len(new)
+	}
+	for i := 0; i < n; i++ {
+		diff(path+"/"+strconv.Itoa(i), old[i], new[i], ops)
+	}
+	for i := n; i < len(new); i++ {
+		*ops = append(*ops, Operation{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: new[i]})
+	}
+	// NOTE: remove from the end, so that the operations can be applied in order
+	for i := len(old) - 1; i >= n; i-- {
+		*ops = append(*ops, Operation{Op: "remove", Path: path + "/" + strconv.Itoa(i), Old: old[i]})
+	}
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func escapePointer(key string) string {
+	return pointerEscaper.Replace(key)
+}
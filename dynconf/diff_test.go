@@ -0,0 +1,65 @@
+package dynconf_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	type Item struct {
+		Name string `json:"name"`
+	}
+	type Value struct {
+		Rate  int               `json:"rate"`
+		Tags  []string          `json:"tags"`
+		Items []Item            `json:"items"`
+		Attrs map[string]string `json:"attrs"`
+	}
+	old := Value{
+		Rate:  1,
+		Tags:  []string{"a", "b", "c"},
+		Items: []Item{{Name: "x"}},
+		Attrs: map[string]string{"a/b": "1", "removed": "2"},
+	}
+	new := Value{
+		Rate:  2,
+		Tags:  []string{"a"},
+		Items: []Item{{Name: "y"}, {Name: "z"}},
+		Attrs: map[string]string{"a/b": "1", "added": "3"},
+	}
+	ops, err := dynconf.Diff(old, new)
+	assert.Nilf(t, err, "diff")
+	assert.Equalf(t, []dynconf.Operation{
+		{Op: "add", Path: "/attrs/added", Value: "3"},
+		{Op: "remove", Path: "/attrs/removed", Old: "2"},
+		{Op: "replace", Path: "/items/0/name", Value: "y", Old: "x"},
+		{Op: "add", Path: "/items/1", Value: map[string]any{"name": "z"}},
+		{Op: "replace", Path: "/rate", Value: float64(2), Old: float64(1)},
+		{Op: "remove", Path: "/tags/2", Old: "c"},
+		{Op: "remove", Path: "/tags/1", Old: "b"},
+	}, ops, "diff")
+
+	ops, err = dynconf.Diff(old, old)
+	assert.Nilf(t, err, "no diff")
+	assert.Emptyf(t, ops, "no diff")
+
+	ops, err = dynconf.Diff(false, true)
+	assert.Nilf(t, err, "diff scalar")
+	assert.Equalf(t, []dynconf.Operation{{Op: "replace", Path: "", Value: true, Old: false}}, ops, "diff scalar")
+
+	ops, err = dynconf.Diff(map[string]string{"a/b~c": "1"}, map[string]string{})
+	assert.Nilf(t, err, "escape")
+	assert.Equalf(t, "/a~1b~0c", ops[0].Path, "escape")
+
+	ops, err = dynconf.Diff(Value{Rate: 1, Tags: []string{"a"}}, Value{Tags: []string{""}})
+	assert.Nilf(t, err, "zero value")
+	data, err := json.Marshal(ops)
+	assert.Nilf(t, err, "marshal zero value")
+	assert.JSONEqf(t, `[
+		{"op": "replace", "path": "/rate", "value": 0, "old": 1},
+		{"op": "replace", "path": "/tags/0", "value": "", "old": "a"}
+	]`, string(data), "zero value not omitted")
+}
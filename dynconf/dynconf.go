@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/multierr"
 )
 
 func init() {
@@ -12,8 +13,28 @@ func init() {
 }
 
 type Dynconf struct {
+	// Keys is the KeyProvider used to decrypt secrets in data, see `SecretPrefix`, default is the one set by `SetKeyProvider`
+	Keys      json.RawMessage `json:"keys,omitempty" caddy:"namespace=config.ext.dynconf.keys inline_key=provider"`
 	Callbacks `json:"callbacks"`
 	Listeners []json.RawMessage `json:"listeners" caddy:"namespace=config.ext.dynconf.listeners inline_key=listener"`
+
+	listeners []any
+}
+
+// throttleFlusher is implemented by listeners embedding ListenerOptions
+type throttleFlusher interface {
+	FlushThrottles()
+}
+
+// settingsBinder is implemented by listeners embedding ListenerOptions
+type settingsBinder interface {
+	bindSettings(s *settings)
+}
+
+// Readier is implemented by listeners which report whether the initial data has been delivered, see
+// `ListenerOptions.Delivered`
+type Readier interface {
+	Ready() error
 }
 
 func (d Dynconf) ID() string {
@@ -28,21 +49,91 @@ func (d Dynconf) CaddyModule() caddy.ModuleInfo {
 	}
 }
 
-// Provision implement caddy.Provisioner, execute callbacks with default config
+// Provision implement caddy.Provisioner, execute callbacks with default config, then load the listeners which execute
+// callbacks with the settings of this config(see `Callbacks`)
+//
+// NOTE: the settings of this config never affect the listeners of the running config, even if this config is rejected
+// later, only the callbacks executed without context(e.g. pushed by admin api) use the settings of the config
+// provisioned latest, see `Cleanup`
 func (d *Dynconf) Provision(ctx caddy.Context) error {
+	// NOTE: load keys before callbacks, since the default config may contain secrets
+	if d.Keys != nil {
+		mod, err := ctx.LoadModule(d, "Keys")
+		if err != nil {
+			return fmt.Errorf("%s load keys failed: %v", d.ID(), err)
+		}
+		p, ok := mod.(KeyProvider)
+		if !ok {
+			return fmt.Errorf("%s: keys %T is not a KeyProvider", d.ID(), mod)
+		}
+		d.Callbacks.keys = p
+	}
 	err := d.Callbacks.Provision(ctx)
 	if err != nil {
 		return fmt.Errorf("provision callbacks failed: %v", err)
 	}
-	_, err = ctx.LoadModule(d, "Listeners")
+	// NOTE: the listeners capture the settings from the context, see `ListenerOptions.CallbackContext`
+	ctx.Context = withSettings(ctx.Context, d.Callbacks.settings)
+	mods, err := ctx.LoadModule(d, "Listeners")
 	if err != nil {
 		return fmt.Errorf("%s load listeners failed: %v", d.ID(), err)
 	}
+	d.listeners, _ = mods.([]any)
 	d.Listeners = nil // allow GC to deallocate
+	for _, listener := range d.listeners {
+		if b, ok := listener.(settingsBinder); ok {
+			b.bindSettings(d.Callbacks.settings)
+		}
+	}
+	activate(d.Callbacks.settings)
+	return nil
+}
+
+// Cleanup implement caddy.CleanerUpper, apply the data delayed by throttles of the listeners(see
+// `ListenerOptions.FlushThrottles`), then stop the listeners in reverse order, so that the listeners are torn down
+// after the modules depending on them.
+//
+// The settings of this config are no longer used by the callbacks executed without context, the ones of the config
+// provisioned latest among the running are used instead, or the defaults if none.
+//
+// NOTE: caddy calls Cleanup of dynconf and the listeners again when the context is canceled, so Cleanup of listeners
+// must be idempotent
+func (d *Dynconf) Cleanup() error {
+	for _, listener := range d.listeners {
+		if f, ok := listener.(throttleFlusher); ok {
+			f.FlushThrottles()
+		}
+	}
+	var errs error
+	for i := len(d.listeners) - 1; i >= 0; i-- {
+		if c, ok := d.listeners[i].(caddy.CleanerUpper); ok {
+			if err := c.Cleanup(); err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("cleanup listener %T failed: %v", d.listeners[i], err))
+			}
+		}
+	}
+	deactivate(d.Callbacks.settings)
+	return errs
+}
+
+// Ready implement caddyconfig.Ready, returns error until each listener has delivered the initial data(or the snapshot
+// has been applied instead and not stale)
+func (d *Dynconf) Ready() error {
+	var errs error
+	for _, listener := range d.listeners {
+		if r, ok := listener.(Readier); ok {
+			errs = multierr.Append(errs, r.Ready())
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("%s not ready: %v", d.ID(), errs)
+	}
 	return nil
 }
 
 // Interface guard
 var (
-	_ caddy.Provisioner = (*Dynconf)(nil)
+	_ caddy.Provisioner  = (*Dynconf)(nil)
+	_ caddy.CleanerUpper = (*Dynconf)(nil)
+	_ Readier            = (*Dynconf)(nil)
 )
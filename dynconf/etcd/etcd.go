@@ -0,0 +1,297 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(Etcd{})
+}
+
+const (
+	DefaultDialTimeout   = 5 * time.Second
+	DefaultTimeout       = 10 * time.Second
+	DefaultRetryInterval = time.Second
+)
+
+// Etcd watch keys or prefixes on etcd v3, and execute the referenced callbacks with the initial value and every change
+// of each key, the sourceKey passed to callbacks is the etcd key. The last revision applied successfully is recorded,
+// so that watching resumes from it after reconnecting and no updates are lost, and the whole value is reloaded if
+// failed to apply. Keys changed in the same watch response(e.g. by a txn) are executed as a batch(see
+// `dynconf.ExecuteBatch`).
+//
+// NOTE: deleting a key is only logged, the callbacks are not executed and keep the last value
+//
+// {
+//     "listener": "etcd",
+//     "endpoints": ["127.0.0.1:2379"],
+//     "datas": [
+//         {
+//             "key": "/config/degrade",
+//             "callbacks": ["/config/degrade"]
+//         },
+//         {
+//             "key": "/config/dynamic/",
+//             "prefix": true,
+//             "callbacks": ["dynamic"]
+//         }
+//     ]
+// }
+type Etcd struct {
+	Endpoints []string `json:"endpoints"`
+	Username  string   `json:"username,omitempty"`
+	Password  string   `json:"password,omitempty"`
+	// DialTimeout default is 5s
+	DialTimeout caddy.Duration `json:"dial_timeout,omitempty"`
+	// Timeout used to get keys, default is 10s
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+	// RetryInterval used to wait before watching again when failed, default is 1s
+	RetryInterval caddy.Duration `json:"retry_interval,omitempty"`
+	Datas         []EtcdData     `json:"datas"`
+	dynconf.ListenerOptions
+
+	client *clientv3.Client
+	logger *zap.Logger
+	cancel context.CancelFunc
+	wg     *sync.WaitGroup
+}
+
+// EtcdData define a key(or prefix) to watch and the callbacks to execute with its value
+type EtcdData struct {
+	Key string `json:"key"`
+	// Prefix if true, all keys with prefix Key are watched
+	Prefix bool `json:"prefix,omitempty"`
+	dynconf.DataOptions
+	Callbacks []typemap.Ref[dynconf.Callback] `json:"callbacks"`
+
+	revision int64 // last revision applied successfully, 0 means not loaded yet
+}
+
+func (ed *EtcdData) options() []clientv3.OpOption {
+	if ed.Prefix {
+		return []clientv3.OpOption{clientv3.WithPrefix()}
+	}
+	return nil
+}
+
+func (e Etcd) ID() string {
+	return "config.ext.dynconf.listeners.etcd"
+}
+
+// CaddyModule returns the Caddy module information.
+func (e Etcd) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  caddy.ModuleID(e.ID()),
+		New: func() caddy.Module { return new(Etcd) },
+	}
+}
+
+// Provision implement caddy.Provisioner, execute callbacks with the initial value of keys and start watching
+func (e *Etcd) Provision(ctx caddy.Context) error {
+	e.logger = ctx.Logger(e)
+	if len(e.Endpoints) == 0 {
+		return fmt.Errorf("%s: endpoints is empty", e.ID())
+	}
+	if e.DialTimeout <= 0 {
+		e.DialTimeout = caddy.Duration(DefaultDialTimeout)
+	}
+	if e.Timeout <= 0 {
+		e.Timeout = caddy.Duration(DefaultTimeout)
+	}
+	if e.RetryInterval <= 0 {
+		e.RetryInterval = caddy.Duration(DefaultRetryInterval)
+	}
+	for i := range e.Datas {
+		if e.Datas[i].Key == "" {
+			return fmt.Errorf("%s: datas[%d] key is empty", e.ID(), i)
+		}
+		if err := e.Datas[i].Format.Validate(); err != nil {
+			return fmt.Errorf("%s: datas[%d] %v", e.ID(), i, err)
+		}
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   e.Endpoints,
+		Username:    e.Username,
+		Password:    e.Password,
+		DialTimeout: time.Duration(e.DialTimeout),
+		Logger:      e.logger.Named("client"),
+	})
+	if err != nil {
+		return fmt.Errorf("%s: create client failed: %v", e.ID(), err)
+	}
+	e.client = client
+	for i := range e.Datas {
+		data := &e.Datas[i]
+		err := e.load(ctx, data)
+		if err != nil {
+			return fmt.Errorf("%s: execute callbacks of %s with initial value failed: %v", e.ID(), data.Key, err)
+		}
+		if data.revision == 0 {
+			if data.Prefix {
+				e.ApplySnapshotsPrefix(ctx, data.Key)
+			} else {
+				e.ApplySnapshots(ctx, data.Key)
+			}
+		}
+	}
+	watchCtx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.wg = &sync.WaitGroup{}
+	for i := range e.Datas {
+		e.wg.Add(1)
+		go e.watch(watchCtx, &e.Datas[i])
+	}
+	return nil
+}
+
+// Cleanup implement caddy.CleanerUpper, stop watching and close the client
+func (e *Etcd) Cleanup() error {
+	if e.cancel != nil {
+		e.cancel()
+		e.wg.Wait()
+	}
+	e.DeleteConnected()
+	// NOTE: closing client twice returns error, see `dynconf.Dynconf.Cleanup`
+	if client := e.client; client != nil {
+		e.client = nil
+		return client.Close()
+	}
+	return nil
+}
+
+// load get the current value of data and execute callbacks, failing to get is only logged, the revision is recorded
+// only if succeeded
+func (e *Etcd) load(ctx context.Context, data *EtcdData) error {
+	getCtx, cancel := context.WithTimeout(ctx, time.Duration(e.Timeout))
+	defer cancel()
+	resp, err := e.client.Get(getCtx, data.Key, data.options()...)
+	e.SetConnected(e.ID(), strings.Join(e.Endpoints, ","), err == nil)
+	if err != nil {
+		e.logger.Warn("get etcd key failed", zap.String("key", data.Key), zap.Error(err))
+		return nil
+	}
+	changes := make([]dynconf.Change, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		changes = append(changes, dynconf.Change{
+			SourceKey: string(kv.Key),
+			Data:      string(kv.Value),
+			Format:    data.Format,
+			Callbacks: data.Callbacks,
+		})
+	}
+	if len(changes) > 0 {
+		err = e.ExecuteBatch(ctx, changes)
+		if err != nil {
+			return err
+		}
+	}
+	data.revision = resp.Header.Revision
+	e.MarkDelivered(data.Key)
+	return nil
+}
+
+func (e *Etcd) watch(ctx context.Context, data *EtcdData) {
+	defer e.wg.Done()
+	for {
+		if data.revision == 0 {
+			err := e.load(ctx, data)
+			if err != nil {
+				e.logger.Error("execute callbacks failed", zap.String("key", data.Key), zap.Error(err))
+			}
+		}
+		if data.revision > 0 {
+			e.watchFrom(ctx, data)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(e.RetryInterval)):
+		}
+	}
+}
+
+// watchFrom watch data from the revision after last applied until the watch channel is closed, or failed to apply, then
+// the whole value is reloaded
+func (e *Etcd) watchFrom(ctx context.Context, data *EtcdData) {
+	watchCtx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+	opts := append(data.options(), clientv3.WithRev(data.revision+1))
+	for resp := range e.client.Watch(watchCtx, data.Key, opts...) {
+		if resp.CompactRevision != 0 {
+			// NOTE: revisions we have not seen are compacted, reload the whole value
+			e.logger.Warn("etcd revision compacted, reload", zap.String("key", data.Key),
+				zap.Int64("revision", data.revision), zap.Int64("compact_revision", resp.CompactRevision))
+			data.revision = 0
+			return
+		}
+		if err := resp.Err(); err != nil {
+			if ctx.Err() == nil {
+				e.SetConnected(e.ID(), strings.Join(e.Endpoints, ","), false)
+				e.logger.Warn("watch etcd key failed", zap.String("key", data.Key), zap.Error(err))
+			}
+			return
+		}
+		var changes []dynconf.Change
+		var revision int64
+		for _, event := range resp.Events {
+			revision = event.Kv.ModRevision
+			key := string(event.Kv.Key)
+			if event.Type == mvccpb.DELETE {
+				e.logger.Warn("etcd key deleted", zap.String("key", key))
+				continue
+			}
+			changes = append(changes, dynconf.Change{
+				SourceKey: key,
+				Data:      string(event.Kv.Value),
+				Format:    data.Format,
+				Callbacks: data.Callbacks,
+			})
+		}
+		if len(changes) > 0 {
+			err := e.ExecuteBatch(ctx, changes)
+			if err != nil {
+				e.logger.Error("execute callbacks failed, reload", zap.String("key", data.Key), zap.Error(err))
+				data.revision = 0
+				return
+			}
+		}
+		if revision > 0 {
+			data.revision = revision
+		}
+	}
+}
+
+// Ready implement dynconf.Readier, returns error until each key or prefix has been loaded, or the snapshot of it
+// has been applied
+func (e *Etcd) Ready() error {
+	var pendings []string
+	for i := range e.Datas {
+		data := &e.Datas[i]
+		if e.Delivered(data.Key) || (data.Prefix && e.DeliveredPrefix(data.Key)) {
+			continue
+		}
+		pendings = append(pendings, data.Key)
+	}
+	if len(pendings) > 0 {
+		return fmt.Errorf("%s: initial value of %s not loaded", e.ID(), strings.Join(pendings, ", "))
+	}
+	return nil
+}
+
+// Interface guard
+var (
+	_ caddy.Provisioner  = (*Etcd)(nil)
+	_ caddy.CleanerUpper = (*Etcd)(nil)
+	_ dynconf.Readier    = (*Etcd)(nil)
+)
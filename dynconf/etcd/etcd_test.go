@@ -0,0 +1,190 @@
+package etcd_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/caddy-config/dynconf/etcd"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func startEtcd(t *testing.T, dir string, clientURL, peerURL url.URL) *embed.Etcd {
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	cfg.LCUrls = []url.URL{clientURL}
+	cfg.ACUrls = []url.URL{clientURL}
+	cfg.LPUrls = []url.URL{peerURL}
+	cfg.APUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Close()
+		t.Fatal("etcd server start timeout")
+	}
+	return e
+}
+
+func put(t *testing.T, endpoint, key, value string) {
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = client.Put(ctx, key, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEtcd(t *testing.T) {
+	dir := t.TempDir()
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	server := startEtcd(t, dir, clientURL, peerURL)
+	defer func() { server.Close() }()
+	endpoint := clientURL.Host
+	put(t, endpoint, "/etcd/switch", `{"name": "etcd_switch", "value": true}`)
+	put(t, endpoint, "/etcd/dynamic/a", "a")
+
+	ctx := context.Background()
+	var lock sync.Mutex
+	received := make(map[string]string)
+	typemap.MustRegister[dynconf.Callback](ctx, "etcd:switch", dynconf.NewRegCallback[bool]("etcd_switch"))
+	typemap.MustRegister[dynconf.Callback](ctx, "etcd:record", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		lock.Lock()
+		received[sourceKey] = data
+		lock.Unlock()
+		return nil
+	}))
+	e := &etcd.Etcd{}
+	err := json.Unmarshal([]byte(fmt.Sprintf(`{
+		"endpoints": [%q],
+		"retry_interval": "100ms",
+		"datas": [
+			{
+				"key": "/etcd/switch",
+				"callbacks": ["etcd:switch"]
+			},
+			{
+				"key": "/etcd/dynamic/",
+				"prefix": true,
+				"callbacks": ["etcd:record"]
+			}
+		]
+	}`, endpoint)), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	err = e.Provision(caddyCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Cleanup()
+	assert.Nilf(t, e.Ready(), "ready after initial data delivered")
+	value, err := typemap.Get[bool](ctx, "etcd_switch")
+	assert.Nilf(t, err, "initial value")
+	assert.Truef(t, value, "initial value")
+	lock.Lock()
+	assert.Equalf(t, map[string]string{"/etcd/dynamic/a": "a"}, received, "initial prefix values")
+	lock.Unlock()
+
+	put(t, endpoint, "/etcd/switch", `{"name": "etcd_switch", "value": false}`)
+	put(t, endpoint, "/etcd/dynamic/b", "b")
+	assert.Eventuallyf(t, func() bool {
+		value, err := typemap.Get[bool](ctx, "etcd_switch")
+		return err == nil && !value
+	}, 5*time.Second, 10*time.Millisecond, "changed value")
+	assert.Eventuallyf(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return received["/etcd/dynamic/b"] == "b"
+	}, 5*time.Second, 10*time.Millisecond, "new key with prefix")
+
+	// NOTE: restart server, updates after reconnecting should not be lost
+	server.Close()
+	server = startEtcd(t, dir, clientURL, peerURL)
+	put(t, endpoint, "/etcd/switch", `{"name": "etcd_switch", "value": true}`)
+	assert.Eventuallyf(t, func() bool {
+		value, err := typemap.Get[bool](ctx, "etcd_switch")
+		return err == nil && value
+	}, 10*time.Second, 10*time.Millisecond, "changed value after reconnecting")
+
+	err = e.Cleanup()
+	assert.Nilf(t, err, "cleanup")
+}
+
+func TestEtcdRetryFailedBatch(t *testing.T) {
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	server := startEtcd(t, t.TempDir(), clientURL, peerURL)
+	defer server.Close()
+	endpoint := clientURL.Host
+	put(t, endpoint, "/etcd/retry", "initial")
+
+	var lock sync.Mutex
+	var received []string
+	typemap.MustRegister[dynconf.Callback](context.Background(), "etcd:retry", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		received = append(received, data)
+		if len(received) == 2 {
+			return fmt.Errorf("apply failed")
+		}
+		return nil
+	}))
+	e := &etcd.Etcd{}
+	err := json.Unmarshal([]byte(fmt.Sprintf(`{
+		"endpoints": [%q],
+		"retry_interval": "100ms",
+		"datas": [{"key": "/etcd/retry", "callbacks": ["etcd:retry"]}]
+	}`, endpoint)), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	err = e.Provision(caddyCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Cleanup()
+
+	put(t, endpoint, "/etcd/retry", "changed")
+	assert.Eventuallyf(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(received) == 3
+	}, 5*time.Second, 10*time.Millisecond, "reload after failed")
+	time.Sleep(300 * time.Millisecond)
+	lock.Lock()
+	assert.Equalf(t, []string{"initial", "changed", "changed"}, received, "applied again and only once after succeeded")
+	lock.Unlock()
+}
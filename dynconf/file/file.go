@@ -0,0 +1,259 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(File{})
+}
+
+// File watch local files or directories, and execute the referenced callbacks with the initial content
+// and every change of each file, the sourceKey passed to callbacks is the cleaned path of the file,
+// it is designed for development and ci which have no config center
+//
+// {
+//     "listener": "file",
+//     "datas": [
+//         {
+//             "path": "./conf/degrade.json",
+//             "callbacks": ["./conf/degrade.json"]
+//         },
+//         {
+//             "path": "./conf/dynamic",
+//             "pattern": "*.json",
+//             "callbacks": ["dynamic"]
+//         }
+//     ]
+// }
+type File struct {
+	Datas []FileData `json:"datas"`
+	dynconf.ListenerOptions
+
+	logger  *zap.Logger
+	watcher *fsnotify.Watcher
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// FileData define a file or directory to watch and the callbacks to execute with file content
+type FileData struct {
+	// Path is a file or directory, if directory, all regular files(matched with Pattern) directly in it are watched,
+	// a missing file is allowed if its directory exists, the snapshot is applied instead until it is created
+	Path string `json:"path"`
+	// Pattern used to filter files' base name in directory, refer to `filepath.Match`, default match all
+	Pattern string `json:"pattern,omitempty"`
+	dynconf.DataOptions
+	Callbacks []typemap.Ref[dynconf.Callback] `json:"callbacks"`
+
+	dir      bool
+	contents map[string]string // path -> last content applied successfully
+}
+
+// match reports whether the path belongs to this data
+func (fd *FileData) match(path string) bool {
+	if !fd.dir {
+		return path == fd.Path
+	}
+	if filepath.Dir(path) != fd.Path {
+		return false
+	}
+	if fd.Pattern == "" {
+		return true
+	}
+	ok, _ := filepath.Match(fd.Pattern, filepath.Base(path))
+	return ok
+}
+
+func (f File) ID() string {
+	return "config.ext.dynconf.listeners.file"
+}
+
+// CaddyModule returns the Caddy module information.
+func (f File) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  caddy.ModuleID(f.ID()),
+		New: func() caddy.Module { return new(File) },
+	}
+}
+
+// Provision implement caddy.Provisioner, execute callbacks with the initial content of files and start watching
+func (f *File) Provision(ctx caddy.Context) error {
+	f.logger = ctx.Logger(f)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("%s: create watcher failed: %v", f.ID(), err)
+	}
+	f.watcher = watcher
+	for i := range f.Datas {
+		data := &f.Datas[i]
+		if data.Path == "" {
+			return fmt.Errorf("%s: datas[%d] path is empty", f.ID(), i)
+		}
+		if err := data.Format.Validate(); err != nil {
+			return fmt.Errorf("%s: datas[%d] %v", f.ID(), i, err)
+		}
+		data.Path = filepath.Clean(data.Path)
+		info, err := os.Stat(data.Path)
+		switch {
+		case err == nil:
+			data.dir = info.IsDir()
+		case os.IsNotExist(err):
+			f.logger.Warn("file not exists", zap.String("source_key", data.Path))
+		default:
+			return fmt.Errorf("%s: stat %s failed: %v", f.ID(), data.Path, err)
+		}
+		data.contents = make(map[string]string)
+		// NOTE: watch the directory rather than the file itself, so that atomic replacement(rename) by editors can be detected
+		dir := data.Path
+		if !data.dir {
+			dir = filepath.Dir(data.Path)
+		}
+		err = watcher.Add(dir)
+		if err != nil {
+			return fmt.Errorf("%s: watch %s failed: %v", f.ID(), dir, err)
+		}
+		paths := []string{data.Path}
+		if data.dir {
+			paths, err = f.list(data)
+			if err != nil {
+				return err
+			}
+		}
+		for _, path := range paths {
+			err = f.refresh(ctx, data, path)
+			if err != nil {
+				return fmt.Errorf("%s: execute callbacks of %s with initial content failed: %v", f.ID(), path, err)
+			}
+		}
+		if _, ok := data.contents[data.Path]; !data.dir && !ok {
+			f.ApplySnapshots(ctx, data.Path)
+		}
+	}
+	watchCtx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	f.done = make(chan struct{})
+	go f.watch(watchCtx)
+	return nil
+}
+
+// Cleanup implement caddy.CleanerUpper, stop watching
+func (f *File) Cleanup() error {
+	if f.watcher == nil {
+		return nil
+	}
+	if f.cancel != nil {
+		f.cancel()
+	}
+	err := f.watcher.Close()
+	if f.done != nil {
+		<-f.done
+	}
+	return err
+}
+
+// Ready implement dynconf.Readier, returns error until the initial content of each file has been delivered, the files
+// in directories are not checked, since the empty ones are ignored
+func (f *File) Ready() error {
+	var pendings []string
+	for i := range f.Datas {
+		if data := &f.Datas[i]; !data.dir && !f.Delivered(data.Path) {
+			pendings = append(pendings, data.Path)
+		}
+	}
+	if len(pendings) > 0 {
+		return fmt.Errorf("%s: initial content of %s not delivered", f.ID(), strings.Join(pendings, ", "))
+	}
+	return nil
+}
+
+func (f *File) list(data *FileData) ([]string, error) {
+	entries, err := os.ReadDir(data.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: read dir %s failed: %v", f.ID(), data.Path, err)
+	}
+	var paths []string
+	for _, entry := range entries {
+		path := filepath.Join(data.Path, entry.Name())
+		if entry.Type().IsRegular() && data.match(path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+func (f *File) watch(ctx context.Context) {
+	defer close(f.done)
+	for {
+		select {
+		case event, ok := <-f.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			path := filepath.Clean(event.Name)
+			for i := range f.Datas {
+				data := &f.Datas[i]
+				if !data.match(path) {
+					continue
+				}
+				err := f.refresh(ctx, data, path)
+				if err != nil {
+					f.logger.Error("execute callbacks failed", zap.String("source_key", path), zap.Error(err))
+				}
+			}
+		case err, ok := <-f.watcher.Errors:
+			if !ok {
+				return
+			}
+			f.logger.Warn("watch files failed", zap.Error(err))
+		}
+	}
+}
+
+// refresh read the content of path and execute callbacks if changed, failing to read or empty content is ignored
+// since the file may be removed or in the middle of being written, the content is recorded only if succeeded, so that
+// the failed one is applied again when the file is written next time
+func (f *File) refresh(ctx context.Context, data *FileData, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		f.logger.Warn("read file failed", zap.String("source_key", path), zap.Error(err))
+		return nil
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	content := string(b)
+	if last, ok := data.contents[path]; ok && last == content {
+		return nil
+	}
+	converted, err := dynconf.ToJSON(data.Format, content)
+	if err != nil {
+		return fmt.Errorf("convert %s from %s failed: %v", path, data.Format, err)
+	}
+	err = f.Execute(dynconf.WithSourceFormat(ctx, data.Format), data.Callbacks, path, converted)
+	if err != nil {
+		return err
+	}
+	data.contents[path] = content
+	return nil
+}
+
+// Interface guard
+var (
+	_ caddy.Provisioner  = (*File)(nil)
+	_ caddy.CleanerUpper = (*File)(nil)
+	_ dynconf.Readier    = (*File)(nil)
+)
@@ -0,0 +1,200 @@
+package file_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/caddy-config/dynconf/file"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "switch.json")
+	err := os.WriteFile(path, []byte(`{"name": "file_switch", "value": true}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confDir := filepath.Join(dir, "conf")
+	err = os.Mkdir(confDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(confDir, "a.json"), []byte(`a`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(confDir, "b.txt"), []byte(`b`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lock sync.Mutex
+	received := make(map[string]string)
+	typemap.MustRegister[dynconf.Callback](ctx, "file:switch", dynconf.NewRegCallback[bool]("file_switch"))
+	typemap.MustRegister[dynconf.Callback](ctx, "file:record", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		lock.Lock()
+		received[sourceKey] = data
+		lock.Unlock()
+		return nil
+	}))
+
+	f := &file.File{}
+	err = json.Unmarshal([]byte(fmt.Sprintf(`{
+		"datas": [
+			{
+				"path": %q,
+				"callbacks": ["file:switch"]
+			},
+			{
+				"path": %q,
+				"pattern": "*.json",
+				"callbacks": ["file:record"]
+			}
+		]
+	}`, path, confDir)), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	err = f.Provision(caddyCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Cleanup()
+	assert.Nilf(t, f.Ready(), "ready after initial data delivered")
+	value, err := typemap.Get[bool](ctx, "file_switch")
+	assert.Nilf(t, err, "initial value")
+	assert.Truef(t, value, "initial value")
+	lock.Lock()
+	assert.Equalf(t, map[string]string{filepath.Join(confDir, "a.json"): "a"}, received, "initial dir contents")
+	lock.Unlock()
+
+	err = os.WriteFile(path, []byte(`{"name": "file_switch", "value": false}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventuallyf(t, func() bool {
+		value, err := typemap.Get[bool](ctx, "file_switch")
+		return err == nil && !value
+	}, 3*time.Second, 10*time.Millisecond, "changed value")
+
+	// NOTE: atomic replacement
+	tmp := filepath.Join(dir, "c.tmp")
+	err = os.WriteFile(tmp, []byte(`c`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(tmp, filepath.Join(confDir, "c.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventuallyf(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return received[filepath.Join(confDir, "c.json")] == "c"
+	}, 3*time.Second, 10*time.Millisecond, "new file in dir")
+	lock.Lock()
+	_, ok := received[filepath.Join(confDir, "b.txt")]
+	lock.Unlock()
+	assert.Falsef(t, ok, "pattern not matched")
+
+	err = f.Cleanup()
+	assert.Nilf(t, err, "cleanup")
+}
+
+func newFile(t *testing.T, path, callback string) *file.File {
+	f := &file.File{}
+	err := json.Unmarshal([]byte(fmt.Sprintf(`{"datas": [{"path": %q, "callbacks": [%q]}]}`, path, callback)), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	t.Cleanup(cancel)
+	err = f.Provision(caddyCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Cleanup() })
+	return f
+}
+
+func TestFileMissing(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "file:missing", dynconf.NewRegCallback[bool]("file_missing"))
+	dir := t.TempDir()
+	path := filepath.Join(dir, "missing.json")
+	snapshotDir := t.TempDir()
+	err := dynconf.SetSnapshotDir(snapshotDir, 0)
+	assert.Nilf(t, err, "set snapshot dir")
+	defer dynconf.SetSnapshotDir("", 0)
+	b, _ := json.Marshal(dynconf.Snapshot{
+		SourceKey: path,
+		Data:      `{"name": "file_missing", "value": true}`,
+		Callbacks: []string{"file:missing"},
+		SavedAt:   time.Now(),
+	})
+	err = os.WriteFile(filepath.Join(snapshotDir, url.PathEscape(path)+".json"), b, 0600)
+	assert.Nilf(t, err, "write snapshot")
+
+	f := newFile(t, path, "file:missing")
+	value, err := typemap.Get[bool](ctx, "file_missing")
+	assert.Nilf(t, err, "snapshot applied")
+	assert.Truef(t, value, "snapshot applied")
+	assert.Nilf(t, f.Ready(), "ready after snapshot applied")
+
+	err = os.WriteFile(path, []byte(`{"name": "file_missing", "value": false}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventuallyf(t, func() bool {
+		value, err := typemap.Get[bool](ctx, "file_missing")
+		return err == nil && !value
+	}, 3*time.Second, 10*time.Millisecond, "created file applied")
+}
+
+func TestFileRetryFailedApply(t *testing.T) {
+	var calls int32
+	typemap.MustRegister[dynconf.Callback](context.Background(), "file:retry", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		if data == "changed" && atomic.AddInt32(&calls, 1) == 1 {
+			return fmt.Errorf("apply failed")
+		}
+		return nil
+	}))
+	path := filepath.Join(t.TempDir(), "retry")
+	err := os.WriteFile(path, []byte(`initial`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFile(t, path, "file:retry")
+
+	err = os.WriteFile(path, []byte(`changed`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventuallyf(t, func() bool {
+		return atomic.LoadInt32(&calls) == 1
+	}, 3*time.Second, 10*time.Millisecond, "apply failed")
+	time.Sleep(50 * time.Millisecond)
+	// NOTE: the same content is applied again when written again
+	err = os.WriteFile(path, []byte(`changed`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventuallyf(t, func() bool {
+		return atomic.LoadInt32(&calls) == 2
+	}, 3*time.Second, 10*time.Millisecond, "applied again")
+}
@@ -0,0 +1,327 @@
+package dynconf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the format of data delivered by listeners, data in format other than json is converted to json
+// before executing callbacks, e.g. the yaml data
+//
+//	name: rate_limit
+//	value:
+//	  rate: 100
+//	  burst: 10
+//
+// is converted to `{"name":"rate_limit","value":{"burst":10,"rate":100}}`
+type Format string
+
+const (
+	// FormatJSON is the default format, data is passed through
+	FormatJSON Format = "json"
+	// FormatYAML converts yaml data to json
+	FormatYAML Format = "yaml"
+	// FormatTOML converts toml data to json
+	FormatTOML Format = "toml"
+	// FormatProperties converts java .properties data to json, dotted keys are nested into objects, and values are
+	// strings, which are converted to booleans and numbers by RegCallback according to the types of fields, e.g.
+	// `rate = 100` is 100 for an int field, while `version = 1.0` is kept as is for a string field, see `WithSourceFormat`
+	FormatProperties Format = "properties"
+)
+
+type sourceFormatKey struct{}
+
+// WithSourceFormat returns a context carrying the format of the data executed with it before converted to json(see
+// `ToJSON`), used by listeners converting data by themselves, so that RegCallbacks convert the string values of
+// properties to the types of fields
+func WithSourceFormat(ctx context.Context, f Format) context.Context {
+	return context.WithValue(ctx, sourceFormatKey{}, f)
+}
+
+// sourceFormat returns the format carried by ctx, see `WithSourceFormat`
+func sourceFormat(ctx context.Context) Format {
+	f, _ := ctx.Value(sourceFormatKey{}).(Format)
+	return f
+}
+
+// Validate returns error if f is not a valid format, empty means json
+func (f Format) Validate() error {
+	switch f {
+	case "", FormatJSON, FormatYAML, FormatTOML, FormatProperties:
+		return nil
+	}
+	return fmt.Errorf("invalid format %q, available values are: [%s, %s, %s, %s]", f, FormatJSON, FormatYAML, FormatTOML, FormatProperties)
+}
+
+// ToJSON converts data in format f to json, the error references the line number of the original data
+func ToJSON(f Format, data string) (string, error) {
+	var value any
+	switch f {
+	case "", FormatJSON:
+		return data, nil
+	case FormatYAML:
+		err := yaml.Unmarshal([]byte(data), &value)
+		if err != nil {
+			return "", err
+		}
+	case FormatTOML:
+		m := make(map[string]any)
+		_, err := toml.Decode(data, &m)
+		if err != nil {
+			return "", err
+		}
+		value = m
+	case FormatProperties:
+		m, err := parseProperties(data)
+		if err != nil {
+			return "", err
+		}
+		value = m
+	default:
+		return "", f.Validate()
+	}
+	b, err := json.Marshal(jsonCompatible(value))
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", f, err)
+	}
+	return string(b), nil
+}
+
+// jsonCompatible converts the maps with non-string keys(e.g. yaml `1: a`) to map[string]any, and times to strings
+func jsonCompatible(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, fv := range v {
+			v[key] = jsonCompatible(fv)
+		}
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, fv := range v {
+			m[fmt.Sprint(key)] = jsonCompatible(fv)
+		}
+		return m
+	case []any:
+		for i := range v {
+			v[i] = jsonCompatible(v[i])
+		}
+	case []map[string]any: // NOTE: array of tables in toml
+		s := make([]any, len(v))
+		for i := range v {
+			s[i] = jsonCompatible(v[i])
+		}
+		return s
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return value
+}
+
+var numberRegexp = regexp.MustCompile(`^-?(0|[1-9]\d*)(\.\d+)?([eE][+-]?\d+)?$`)
+
+// parseProperties parses java .properties data, the error references the line number
+func parseProperties(data string) (map[string]any, error) {
+	root := make(map[string]any)
+	defined := make(map[string]int) // key -> line
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimLeft(lines[i], " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		// logical line continues if it ends with odd number of backslashes
+		for continued(line) && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+		key, value, err := splitProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("properties: line %d: %v", lineNo, err)
+		}
+		if key == "" {
+			return nil, fmt.Errorf("properties: line %d: empty key", lineNo)
+		}
+		if first, ok := defined[key]; ok {
+			return nil, fmt.Errorf("properties: line %d: duplicate key %s, first defined at line %d", lineNo, key, first)
+		}
+		defined[key] = lineNo
+		err = setProperty(root, strings.Split(key, "."), value)
+		if err != nil {
+			return nil, fmt.Errorf("properties: line %d: %v", lineNo, err)
+		}
+	}
+	return root, nil
+}
+
+func continued(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// splitProperty splits line into unescaped key and value by the first unescaped `=`, `:` or whitespace
+func splitProperty(line string) (string, string, error) {
+	var key strings.Builder
+	i := 0
+	for ; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' {
+			if i+1 >= len(line) {
+				break
+			}
+			i++
+			r, n, err := unescape(line[i:])
+			if err != nil {
+				return "", "", err
+			}
+			key.WriteString(r)
+			i += n - 1
+			continue
+		}
+		if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' {
+			break
+		}
+		key.WriteByte(c)
+	}
+	rest := strings.TrimLeft(line[i:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	var value strings.Builder
+	for j := 0; j < len(rest); j++ {
+		if rest[j] != '\\' || j+1 >= len(rest) {
+			value.WriteByte(rest[j])
+			continue
+		}
+		j++
+		r, n, err := unescape(rest[j:])
+		if err != nil {
+			return "", "", err
+		}
+		value.WriteString(r)
+		j += n - 1
+	}
+	return key.String(), value.String(), nil
+}
+
+// unescape unescapes the sequence after backslash, returns the result and the number of bytes consumed
+func unescape(s string) (string, int, error) {
+	switch s[0] {
+	case 't':
+		return "\t", 1, nil
+	case 'n':
+		return "\n", 1, nil
+	case 'r':
+		return "\r", 1, nil
+	case 'f':
+		return "\f", 1, nil
+	case 'u':
+		if len(s) < 5 {
+			return "", 0, fmt.Errorf("invalid unicode escape \\%s", s)
+		}
+		r, err := strconv.ParseUint(s[1:5], 16, 32)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid unicode escape \\%s", s[:5])
+		}
+		return string(rune(r)), 5, nil
+	}
+	return s[:1], 1, nil
+}
+
+// coerceStrings converts the string values of json value to booleans or numbers if they are decoded into boolean or
+// number fields of type t, used for formats without types, see `FormatProperties`
+func coerceStrings(t reflect.Type, value any) any {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(jsonUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return value
+	}
+	switch v := value.(type) {
+	case string:
+		switch t.Kind() {
+		case reflect.Bool:
+			if v == "true" || v == "false" {
+				return v == "true"
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			if numberRegexp.MatchString(v) {
+				return json.Number(v)
+			}
+		}
+	case map[string]any:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := jsonFields(t)
+			for key, fv := range v {
+				if ft, ok := fields[key]; ok {
+					v[key] = coerceStrings(ft, fv)
+				}
+			}
+		case reflect.Map:
+			for key, fv := range v {
+				v[key] = coerceStrings(t.Elem(), fv)
+			}
+		}
+	case []any:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i := range v {
+				v[i] = coerceStrings(t.Elem(), v[i])
+			}
+		}
+	}
+	return value
+}
+
+func setProperty(m map[string]any, keys []string, value any) error {
+	for i, key := range keys[:len(keys)-1] {
+		next, ok := m[key]
+		if !ok {
+			child := make(map[string]any)
+			m[key] = child
+			m = child
+			continue
+		}
+		child, ok := next.(map[string]any)
+		if !ok {
+			return fmt.Errorf("key %s conflicts with %s", strings.Join(keys, "."), strings.Join(keys[:i+1], "."))
+		}
+		m = child
+	}
+	key := keys[len(keys)-1]
+	if _, ok := m[key]; ok {
+		return fmt.Errorf("key %s conflicts with its sub keys", strings.Join(keys, "."))
+	}
+	m[key] = value
+	return nil
+}
+
+// SetFormat set the format of data of RegCallback with typemap instance name, empty removes it
+func SetFormat(name string, f Format) {
+	setDefaults(func(s *settings) {
+		if f == "" {
+			delete(s.formats, name)
+			return
+		}
+		s.formats[name] = f
+	})
+}
+
+// GetFormat returns the default format of data of RegCallback with typemap instance name, default is json, NOTE: the
+// format set by the config executing the callback takes precedence
+func GetFormat(name string) Format {
+	return getDefaults().getFormat(name)
+}
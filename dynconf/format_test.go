@@ -0,0 +1,153 @@
+package dynconf_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToJSON(t *testing.T) {
+	expected := `{"name": "rate_limit", "value": {"rate": 100, "burst": 10, "enabled": true, "tags": ["a", "b"]}}`
+	cases := []struct {
+		format dynconf.Format
+		data   string
+	}{
+		{dynconf.FormatJSON, expected},
+		{dynconf.FormatYAML, "name: rate_limit\nvalue:\n  rate: 100\n  burst: 10\n  enabled: true\n  tags: [a, b]\n"},
+		{dynconf.FormatTOML, "name = \"rate_limit\"\n[value]\nrate = 100\nburst = 10\nenabled = true\ntags = [\"a\", \"b\"]\n"},
+	}
+	for _, c := range cases {
+		data, err := dynconf.ToJSON(c.format, c.data)
+		assert.Nilf(t, err, "%s", c.format)
+		assert.JSONEqf(t, expected, data, "%s", c.format)
+	}
+	data, err := dynconf.ToJSON(dynconf.FormatProperties, `# rate limit
+name = rate_limit
+value.rate = 100
+value.burst: 10
+value.enabled true
+value.desc = hello \
+    world!
+`)
+	assert.Nilf(t, err, "properties")
+	assert.JSONEqf(t, `{"name": "rate_limit", "value": {"rate": "100", "burst": "10", "enabled": "true", "desc": "hello world!"}}`, data, "properties values are strings")
+
+	invalids := []struct {
+		format dynconf.Format
+		data   string
+		line   string
+	}{
+		{dynconf.FormatYAML, "name: rate_limit\nvalue:\n  rate: 100\n  rate: 10\n", "line 4"},
+		{dynconf.FormatTOML, "name = \"rate_limit\"\n\nname = \"degrade\"\n", "line 3"},
+		{dynconf.FormatProperties, "name = rate_limit\nvalue.rate = 100\n\nvalue.rate = 10\n", "line 4"},
+		{dynconf.FormatProperties, "value = 1\nvalue.rate = 100\n", "line 2"},
+	}
+	for _, e := range invalids {
+		_, err := dynconf.ToJSON(e.format, e.data)
+		assert.NotNilf(t, err, "%s", e.format)
+		assert.Containsf(t, err.Error(), e.line, "%s line number", e.format)
+	}
+	_, err = dynconf.ToJSON("xml", "<a/>")
+	assert.NotNilf(t, err, "invalid format")
+}
+
+func TestRegCallbackFormat(t *testing.T) {
+	ctx := context.Background()
+	cb := dynconf.NewRegCallback[RateLimit]("format_rate_limit")
+	dynconf.SetFormat("format_rate_limit", dynconf.FormatYAML)
+	defer dynconf.SetFormat("format_rate_limit", "")
+	err := cb.Callback("format", "name: format_rate_limit\nvalue:\n  rate: 100\n  burst: 10\n")
+	assert.Nilf(t, err, "yaml")
+	value, _ := typemap.Get[RateLimit](ctx, "format_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 100, Burst: 10}, value, "yaml value")
+	err = cb.Callback("format", "name: format_rate_limit\nvalue:\n  rate: fast\n  burst: 10\n")
+	assert.Truef(t, dynconf.IsValidationError(err), "invalid yaml value")
+
+	err = dynconf.ExecuteBatch(ctx, []dynconf.Change{{
+		SourceKey: "format",
+		Data:      "name = format_rate_limit\nvalue.rate = 1\nvalue.burst = 1\n",
+		Format:    dynconf.FormatProperties,
+		Callbacks: []typemap.Ref[dynconf.Callback]{{Name: "format:rate_limit"}},
+	}})
+	assert.NotNilf(t, err, "callback not registered")
+	typemap.MustRegister[dynconf.Callback](ctx, "format:rate_limit", cb)
+	err = dynconf.ExecuteBatch(ctx, []dynconf.Change{{
+		SourceKey: "format",
+		Data:      "name = format_rate_limit\nvalue.rate = 1\nvalue.burst = 1\n",
+		Format:    dynconf.FormatProperties,
+		Callbacks: []typemap.Ref[dynconf.Callback]{{Name: "format:rate_limit"}},
+	}})
+	assert.Nilf(t, err, "properties change, converted json is also valid yaml")
+	value, _ = typemap.Get[RateLimit](ctx, "format_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 1, Burst: 1}, value, "properties value")
+}
+
+func TestRegCallbackPropertiesString(t *testing.T) {
+	type Account struct {
+		Password string  `json:"password"`
+		Version  string  `json:"version"`
+		Zip      string  `json:"zip"`
+		Retries  int     `json:"retries"`
+		Ratio    float64 `json:"ratio"`
+		Enabled  bool    `json:"enabled"`
+	}
+	ctx := context.Background()
+	cb := dynconf.NewRegCallback[Account]("properties_account")
+	dynconf.SetFormat("properties_account", dynconf.FormatProperties)
+	defer dynconf.SetFormat("properties_account", "")
+	err := cb.Callback("properties", `name = properties_account
+value.password = 123456
+value.version = 1.0
+value.zip = 01234
+value.retries = 3
+value.ratio = 0.5
+value.enabled = true
+`)
+	assert.Nilf(t, err, "numeric strings")
+	value, _ := typemap.Get[Account](ctx, "properties_account")
+	assert.Equalf(t, Account{Password: "123456", Version: "1.0", Zip: "01234", Retries: 3, Ratio: 0.5, Enabled: true}, value, "converted by field types")
+	err = cb.Callback("properties", "name = properties_account\nvalue.retries = three\n")
+	assert.Truef(t, dynconf.IsValidationError(err), "invalid number")
+	err = cb.Callback("properties", `{"name": "properties_account", "value": {"retries": "3"}}`)
+	assert.Truef(t, dynconf.IsValidationError(err), "json strings not converted")
+
+	typemap.MustRegister[dynconf.Callback](ctx, "properties:account", cb)
+	dynconf.SetFormat("properties_account", "")
+	err = dynconf.ExecuteBatch(ctx, []dynconf.Change{{
+		SourceKey: "properties",
+		Data:      "name = properties_account\nvalue.password = 654321\nvalue.version = 2.0\nvalue.zip = 0\nvalue.retries = 5\nvalue.ratio = 1\nvalue.enabled = false\n",
+		Format:    dynconf.FormatProperties,
+		Callbacks: []typemap.Ref[dynconf.Callback]{{Name: "properties:account"}},
+	}})
+	assert.Nilf(t, err, "properties change")
+	value, _ = typemap.Get[Account](ctx, "properties_account")
+	assert.Equalf(t, Account{Password: "654321", Version: "2.0", Zip: "0", Retries: 5, Ratio: 1}, value, "properties change")
+	records := dynconf.History("properties_account")
+	assert.Nilf(t, dynconf.Rollback("properties_account", records[0].Revision), "rollback to the properties change")
+	value, _ = typemap.Get[Account](ctx, "properties_account")
+	assert.Equalf(t, Account{Password: "654321", Version: "2.0", Zip: "0", Retries: 5, Ratio: 1}, value, "rollback to the properties change")
+}
+
+func TestRegCallbackMixedFormat(t *testing.T) {
+	ctx := context.Background()
+	cb := dynconf.NewRegCallback[RateLimit]("mixed_rate_limit")
+	typemap.MustRegister[dynconf.Callback](ctx, "mixed:rate_limit", cb)
+	dynconf.SetFormat("mixed_rate_limit", dynconf.FormatTOML)
+	defer dynconf.SetFormat("mixed_rate_limit", "")
+	err := dynconf.ExecuteBatch(ctx, []dynconf.Change{{
+		SourceKey: "mixed",
+		Data:      "name: mixed_rate_limit\nvalue:\n  rate: 2\n  burst: 3\n",
+		Format:    dynconf.FormatYAML,
+		Callbacks: []typemap.Ref[dynconf.Callback]{{Name: "mixed:rate_limit"}},
+	}})
+	assert.Nilf(t, err, "yaml listener, toml callback")
+	value, _ := typemap.Get[RateLimit](ctx, "mixed_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 2, Burst: 3}, value, "yaml value")
+	err = cb.Callback("mixed", "name = \"mixed_rate_limit\"\n[value]\nrate = 4\nburst = 5\n")
+	assert.Nilf(t, err, "toml callback")
+	value, _ = typemap.Get[RateLimit](ctx, "mixed_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 4, Burst: 5}, value, "toml value")
+}
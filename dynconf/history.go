@@ -0,0 +1,146 @@
+package dynconf
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultHistorySize is the default max number of records kept for each key
+const DefaultHistorySize = 10
+
+// Record is a version of value updated by RegCallback
+type Record struct {
+	// Revision increases by 1 on each update of the key, starts from 1
+	Revision  int64  `json:"revision"`
+	SourceKey string `json:"source_key"`
+	// Data is the original data used to rollback, NOTE: it is not exposed by admin api since it may contain secrets
+	// or fields tagged with `dynconf:"redact"`, see Value
+	Data string `json:"-"`
+	// Value is the redacted value, see `Redact`
+	Value any       `json:"value,omitempty"`
+	Time  time.Time `json:"time"`
+	// Diff is the redacted diff from the previous value, see `Redact` and `Diff`
+	Diff []Operation `json:"diff,omitempty"`
+
+	format Format // the format Data was converted from by listener, see `WithSourceFormat`
+}
+
+// RollbackSourceKey returns the sourceKey used when rollback to revision
+func RollbackSourceKey(revision int64) string {
+	return fmt.Sprintf("rollback:%d", revision)
+}
+
+type keyHistory struct {
+	callback Callback
+	revision int64
+	records  []Record
+}
+
+var histories = struct {
+	sync.RWMutex
+	keys map[string]*keyHistory
+}{
+	keys: make(map[string]*keyHistory),
+}
+
+// SetHistorySize set the default max number of records kept for each key, size <= 0 means DefaultHistorySize, NOTE:
+// the size set by the config executing the callback takes precedence
+func SetHistorySize(size int) {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	setDefaults(func(s *settings) { s.historySize = size })
+	histories.Lock()
+	defer histories.Unlock()
+	for _, h := range histories.keys {
+		if len(h.records) > size {
+			h.records = h.records[len(h.records)-size:]
+		}
+	}
+}
+
+// record append a new record of key updated by callback with data converted from format, at most size records are kept
+func record(size int, key string, callback Callback, sourceKey, data string, format Format, value any, diff []Operation) Record {
+	histories.Lock()
+	defer histories.Unlock()
+	h, ok := histories.keys[key]
+	if !ok {
+		h = &keyHistory{}
+		histories.keys[key] = h
+	}
+	h.callback = callback
+	h.revision++
+	r := Record{
+		Revision:  h.revision,
+		SourceKey: sourceKey,
+		Data:      data,
+		Value:     Redact(value),
+		Time:      time.Now(),
+		Diff:      diff,
+		format:    format,
+	}
+	h.records = append(h.records, r)
+	if len(h.records) > size {
+		h.records = h.records[len(h.records)-size:]
+	}
+	return r
+}
+
+// History returns the records of key(the typemap instance name of RegCallback) from newest to oldest
+func History(key string) []Record {
+	histories.RLock()
+	defer histories.RUnlock()
+	h, ok := histories.keys[key]
+	if !ok {
+		return nil
+	}
+	records := make([]Record, len(h.records))
+	copy(records, h.records)
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Revision > records[j].Revision
+	})
+	return records
+}
+
+// HistoryKeys returns all keys which have history
+func HistoryKeys() []string {
+	histories.RLock()
+	defer histories.RUnlock()
+	keys := make([]string, 0, len(histories.keys))
+	for key := range histories.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Rollback re-apply the data of the specified revision of key through the callback which updated it last time,
+// so the data is validated as a normal update, and a new revision with sourceKey `rollback:{revision}` is recorded
+func Rollback(key string, revision int64) error {
+	histories.RLock()
+	h, ok := histories.keys[key]
+	if !ok {
+		histories.RUnlock()
+		return fmt.Errorf("history of %s not found", key)
+	}
+	callback := h.callback
+	var data string
+	var format Format
+	var found bool
+	for _, r := range h.records {
+		if r.Revision == revision {
+			data, format, found = r.Data, r.format, true
+			break
+		}
+	}
+	histories.RUnlock()
+	if !found {
+		return fmt.Errorf("revision %d of %s not found", revision, key)
+	}
+	ctx, cancel := context.WithTimeout(WithSourceFormat(context.Background(), format), defaultCallbackTimeout())
+	defer cancel()
+	return WithContext(callback).CallbackContext(ctx, RollbackSourceKey(revision), data)
+}
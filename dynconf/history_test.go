@@ -0,0 +1,55 @@
+package dynconf_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	cb := dynconf.NewRegCallback[RateLimit]("history_rate_limit")
+	data := []string{
+		`{"name": "history_rate_limit", "value": {"rate": 1, "burst": 1}}`,
+		`{"name": "history_rate_limit", "value": {"rate": 2, "burst": 2}}`,
+		`{"name": "history_rate_limit", "value": {"rate": 3, "burst": 3}}`,
+	}
+	for _, d := range data {
+		err := cb.Callback("history:rate_limit", d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := cb.Callback("history:rate_limit", `{"name": "history_rate_limit", "value": {"rate": "bad"}}`)
+	assert.NotNilf(t, err, "invalid data")
+
+	records := dynconf.History("history_rate_limit")
+	assert.Lenf(t, records, 3, "invalid data not recorded")
+	assert.Equalf(t, int64(3), records[0].Revision, "newest first")
+	assert.Equalf(t, data[2], records[0].Data, "newest data")
+	assert.Equalf(t, "history:rate_limit", records[0].SourceKey, "source key")
+	assert.Containsf(t, dynconf.HistoryKeys(), "history_rate_limit", "keys")
+
+	err = dynconf.Rollback("history_rate_limit", 1)
+	assert.Nilf(t, err, "rollback")
+	value, _ := typemap.Get[RateLimit](ctx, "history_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 1, Burst: 1}, value, "rollback value")
+	records = dynconf.History("history_rate_limit")
+	assert.Equalf(t, int64(4), records[0].Revision, "rollback is a new revision")
+	assert.Equalf(t, dynconf.RollbackSourceKey(1), records[0].SourceKey, "rollback source key")
+
+	err = dynconf.Rollback("history_rate_limit", 100)
+	assert.NotNilf(t, err, "revision not found")
+	err = dynconf.Rollback("history_not_exists", 1)
+	assert.NotNilf(t, err, "key not found")
+
+	dynconf.SetHistorySize(2)
+	defer dynconf.SetHistorySize(0)
+	records = dynconf.History("history_rate_limit")
+	assert.Lenf(t, records, 2, "bounded")
+	err = dynconf.Rollback("history_rate_limit", 1)
+	assert.NotNilf(t, err, "revision dropped")
+}
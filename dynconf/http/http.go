@@ -0,0 +1,287 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(HTTP{})
+}
+
+const (
+	DefaultInterval = 30 * time.Second
+	DefaultTimeout  = 10 * time.Second
+)
+
+// errNotFound is returned by fetch if the url responds `404 Not Found`, i.e. no body is published yet
+var errNotFound = errors.New("not found")
+
+// HTTP poll(or long poll) urls and execute the referenced callbacks with the initial body and every change of each url,
+// `ETag` of response is sent back with `If-None-Match`, so that server can respond `304 Not Modified` or hold the request
+// until changed(long poll), it is used to integrate with consul kv, apollo, s3-compatible gateways or internal config services
+//
+// {
+//     "listener": "http",
+//     "interval": "10s",
+//     "datas": [
+//         {
+//             "url": "http://127.0.0.1:8500/v1/kv/degrade?raw=true",
+//             "source_key": "consul:degrade",
+//             "callbacks": ["consul:degrade"]
+//         }
+//     ]
+// }
+type HTTP struct {
+	// Interval used to wait between polls, or wait before polling again when failed if long poll, default is 30s
+	Interval caddy.Duration `json:"interval,omitempty"`
+	// Timeout of each request, should be greater than the server holding time if long poll, default is 10s
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+	// LongPoll poll again immediately after the server held the request for at least the interval or responded a change,
+	// otherwise(e.g. the server responds `304 Not Modified` without holding) wait the rest of the interval
+	LongPoll bool `json:"long_poll,omitempty"`
+	// Header used for all requests
+	Header http.Header `json:"header,omitempty"`
+	Datas  []HTTPData  `json:"datas"`
+	dynconf.ListenerOptions
+
+	client *http.Client
+	logger *zap.Logger
+	cancel context.CancelFunc
+	wg     *sync.WaitGroup
+}
+
+// HTTPData define an url to poll and the callbacks to execute with response body
+type HTTPData struct {
+	URL string `json:"url"`
+	// SourceKey passed to callbacks, default is the url
+	SourceKey string `json:"source_key,omitempty"`
+	// Header used for requests of this url, override the common header
+	Header http.Header `json:"header,omitempty"`
+	dynconf.DataOptions
+	Callbacks []typemap.Ref[dynconf.Callback] `json:"callbacks"`
+
+	// etag and content of the body applied successfully, loaded is false if not applied yet
+	etag    string
+	content string
+	loaded  bool
+}
+
+func (h HTTP) ID() string {
+	return "config.ext.dynconf.listeners.http"
+}
+
+// CaddyModule returns the Caddy module information.
+func (h HTTP) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  caddy.ModuleID(h.ID()),
+		New: func() caddy.Module { return new(HTTP) },
+	}
+}
+
+// Provision implement caddy.Provisioner, execute callbacks with the initial body of urls and start polling
+func (h *HTTP) Provision(ctx caddy.Context) error {
+	h.logger = ctx.Logger(h)
+	if h.Interval <= 0 {
+		h.Interval = caddy.Duration(DefaultInterval)
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = caddy.Duration(DefaultTimeout)
+	}
+	h.client = &http.Client{}
+	for i := range h.Datas {
+		data := &h.Datas[i]
+		if data.URL == "" {
+			return fmt.Errorf("%s: datas[%d] url is empty", h.ID(), i)
+		}
+		if err := data.Format.Validate(); err != nil {
+			return fmt.Errorf("%s: datas[%d] %v", h.ID(), i, err)
+		}
+		if data.SourceKey == "" {
+			data.SourceKey = data.URL
+		}
+		err := h.refresh(ctx, data)
+		if err != nil {
+			return fmt.Errorf("%s: execute callbacks of %s with initial body failed: %v", h.ID(), data.SourceKey, err)
+		}
+	}
+	pollCtx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+	h.wg = &sync.WaitGroup{}
+	for i := range h.Datas {
+		h.wg.Add(1)
+		go h.poll(pollCtx, &h.Datas[i])
+	}
+	return nil
+}
+
+// Cleanup implement caddy.CleanerUpper, stop polling
+func (h *HTTP) Cleanup() error {
+	if h.cancel != nil {
+		h.cancel()
+		h.wg.Wait()
+	}
+	h.DeleteConnected()
+	return nil
+}
+
+// Ready implement dynconf.Readier, returns error until the initial body of each url has been delivered, a url responding
+// `404 Not Found` is considered delivered, since there is nothing to wait for until the body is published
+func (h *HTTP) Ready() error {
+	var pendings []string
+	for i := range h.Datas {
+		if sourceKey := h.Datas[i].SourceKey; !h.Delivered(sourceKey) {
+			pendings = append(pendings, sourceKey)
+		}
+	}
+	if len(pendings) > 0 {
+		return fmt.Errorf("%s: initial body of %s not delivered", h.ID(), strings.Join(pendings, ", "))
+	}
+	return nil
+}
+
+func (h *HTTP) poll(ctx context.Context, data *HTTPData) {
+	defer h.wg.Done()
+	// NOTE: the initial body has been requested by Provision, so wait first, unless the server holds the request
+	var wait time.Duration
+	if !h.LongPoll || !data.loaded {
+		wait = time.Duration(h.Interval)
+	}
+	for {
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+		wait = time.Duration(h.Interval)
+		start := time.Now()
+		content, etag, changed, err := h.fetch(ctx, data)
+		if ctx.Err() == nil {
+			h.SetConnected(h.ID(), data.URL, err == nil || errors.Is(err, errNotFound))
+		}
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, errNotFound):
+			h.MarkDelivered(data.SourceKey)
+			h.logger.Warn("url not found", zap.String("source_key", data.SourceKey))
+			continue
+		case err != nil:
+			h.logger.Warn("poll url failed", zap.String("source_key", data.SourceKey), zap.Error(err))
+			continue
+		case changed:
+			err = h.execute(ctx, data, content, etag)
+			if err != nil {
+				h.logger.Error("execute callbacks failed", zap.String("source_key", data.SourceKey), zap.Error(err))
+				continue
+			}
+		}
+		if h.LongPoll {
+			// NOTE: only skip waiting if the server held the request, so that servers not supporting holding
+			// are not requested in a busy loop
+			wait -= time.Since(start)
+			if changed {
+				wait = 0
+			}
+		}
+	}
+}
+
+// refresh request the url and execute callbacks with the body, failing to request is only logged and the snapshot
+// is applied instead, the url not found is marked as delivered without applying the snapshot, since it may have been
+// deleted on purpose, see `Ready`
+func (h *HTTP) refresh(ctx context.Context, data *HTTPData) error {
+	content, etag, changed, err := h.fetch(ctx, data)
+	if err != nil {
+		h.logger.Warn("poll url failed", zap.String("source_key", data.SourceKey), zap.Error(err))
+		if errors.Is(err, errNotFound) {
+			h.MarkDelivered(data.SourceKey)
+		} else {
+			h.ApplySnapshots(ctx, data.SourceKey)
+		}
+		return nil
+	}
+	if !changed {
+		return nil
+	}
+	return h.execute(ctx, data, content, etag)
+}
+
+// execute converts content to json and execute callbacks of data, the etag and content are recorded only if succeeded,
+// so that the failed ones are requested and applied again
+func (h *HTTP) execute(ctx context.Context, data *HTTPData, content, etag string) error {
+	converted, err := dynconf.ToJSON(data.Format, content)
+	if err != nil {
+		return fmt.Errorf("convert %s from %s failed: %v", data.SourceKey, data.Format, err)
+	}
+	err = h.Execute(dynconf.WithSourceFormat(ctx, data.Format), data.Callbacks, data.SourceKey, converted)
+	if err != nil {
+		return err
+	}
+	data.etag, data.content, data.loaded = etag, content, true
+	return nil
+}
+
+// fetch request the url with `If-None-Match`, changed is false if `304 Not Modified` or body not changed since applied
+func (h *HTTP) fetch(ctx context.Context, data *HTTPData) (content, etag string, changed bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(h.Timeout))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, data.URL, nil)
+	if err != nil {
+		return "", "", false, err
+	}
+	for k, vs := range h.Header {
+		req.Header[http.CanonicalHeaderKey(k)] = vs
+	}
+	for k, vs := range data.Header {
+		req.Header[http.CanonicalHeaderKey(k)] = vs
+	}
+	if data.etag != "" {
+		req.Header.Set("If-None-Match", data.etag)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", "", false, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return "", "", false, nil
+	case http.StatusNotFound:
+		return "", "", false, errNotFound
+	default:
+		return "", "", false, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	content, etag = string(body), resp.Header.Get("ETag")
+	if data.loaded && data.content == content {
+		// NOTE: record the etag, so that the server can respond `304 Not Modified` next time
+		data.etag = etag
+		return "", "", false, nil
+	}
+	return content, etag, true, nil
+}
+
+// Interface guard
+var (
+	_ caddy.Provisioner  = (*HTTP)(nil)
+	_ caddy.CleanerUpper = (*HTTP)(nil)
+	_ dynconf.Readier    = (*HTTP)(nil)
+)
@@ -0,0 +1,306 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/ccmonky/caddy-config/dynconf"
+	dynhttp "github.com/ccmonky/caddy-config/dynconf/http"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+// versionedServer serves a content with its version as ETag
+type versionedServer struct {
+	lock        sync.Mutex
+	version     int
+	content     string
+	notModified int32
+}
+
+func (s *versionedServer) publish(content string) {
+	s.lock.Lock()
+	s.version++
+	s.content = content
+	s.lock.Unlock()
+}
+
+func (s *versionedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	etag := strconv.Quote(strconv.Itoa(s.version))
+	content := s.content
+	s.lock.Unlock()
+	if r.Header.Get("X-Token") != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("If-None-Match") == etag {
+		atomic.AddInt32(&s.notModified, 1)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	fmt.Fprint(w, content)
+}
+
+func TestHTTP(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "http:switch", dynconf.NewRegCallback[bool]("http_switch"))
+
+	vs := &versionedServer{}
+	vs.publish(`{"name": "http_switch", "value": true}`)
+	server := httptest.NewServer(vs)
+	defer server.Close()
+
+	h := &dynhttp.HTTP{}
+	err := json.Unmarshal([]byte(fmt.Sprintf(`{
+		"interval": "20ms",
+		"header": {
+			"X-Token": ["secret"]
+		},
+		"datas": [
+			{
+				"url": "%s/switch",
+				"source_key": "http:switch",
+				"callbacks": ["http:switch"]
+			}
+		]
+	}`, server.URL)), h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	err = h.Provision(caddyCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Cleanup()
+	assert.Nilf(t, h.Ready(), "ready after initial data delivered")
+	value, err := typemap.Get[bool](ctx, "http_switch")
+	assert.Nilf(t, err, "initial value")
+	assert.Truef(t, value, "initial value")
+	assert.Eventuallyf(t, func() bool {
+		return atomic.LoadInt32(&vs.notModified) > 0
+	}, 3*time.Second, 10*time.Millisecond, "not modified")
+
+	vs.publish(`{"name": "http_switch", "value": false}`)
+	assert.Eventuallyf(t, func() bool {
+		value, err := typemap.Get[bool](ctx, "http_switch")
+		return err == nil && !value
+	}, 3*time.Second, 10*time.Millisecond, "changed value")
+
+	err = h.Cleanup()
+	assert.Nilf(t, err, "cleanup")
+}
+
+func TestHTTPLongPollNotHeld(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "http:not_held", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		return nil
+	}))
+
+	vs := &versionedServer{}
+	vs.publish(`{"name": "http_not_held", "value": true}`)
+	server := httptest.NewServer(vs)
+	defer server.Close()
+
+	h := &dynhttp.HTTP{}
+	err := json.Unmarshal([]byte(fmt.Sprintf(`{
+		"interval": "100ms",
+		"long_poll": true,
+		"header": {
+			"X-Token": ["secret"]
+		},
+		"datas": [
+			{
+				"url": "%s/not_held",
+				"source_key": "http:not_held",
+				"callbacks": ["http:not_held"]
+			}
+		]
+	}`, server.URL)), h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	err = h.Provision(caddyCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Cleanup()
+	time.Sleep(500 * time.Millisecond)
+	err = h.Cleanup()
+	assert.Nilf(t, err, "cleanup")
+	notModified := atomic.LoadInt32(&vs.notModified)
+	assert.Truef(t, notModified > 0 && notModified <= 6, "wait interval if 304 responded immediately, got %d requests", notModified)
+}
+
+// countingServer serves a fixed content with a fixed ETag and counts the requests
+type countingServer struct {
+	content  string
+	requests int32
+}
+
+func (s *countingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&s.requests, 1)
+	if r.Header.Get("If-None-Match") == `"1"` {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", `"1"`)
+	fmt.Fprint(w, s.content)
+}
+
+func newHTTP(t *testing.T, server *httptest.Server, interval, sourceKey string) *dynhttp.HTTP {
+	h := &dynhttp.HTTP{}
+	err := json.Unmarshal([]byte(fmt.Sprintf(`{
+		"interval": "%s",
+		"header": {
+			"X-Token": ["secret"]
+		},
+		"datas": [
+			{
+				"url": "%s",
+				"source_key": "%s",
+				"callbacks": ["%s"]
+			}
+		]
+	}`, interval, server.URL, sourceKey, sourceKey)), h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	t.Cleanup(cancel)
+	err = h.Provision(caddyCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Cleanup() })
+	return h
+}
+
+func TestHTTPEmptyBody(t *testing.T) {
+	var received int32
+	typemap.MustRegister[dynconf.Callback](context.Background(), "http:empty", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		atomic.AddInt32(&received, 1)
+		return nil
+	}))
+	cs := &countingServer{}
+	server := httptest.NewServer(cs)
+	defer server.Close()
+
+	h := newHTTP(t, server, "1h", "http:empty")
+	assert.Nilf(t, h.Ready(), "empty body delivered")
+	assert.Equalf(t, int32(1), atomic.LoadInt32(&received), "delivered once")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equalf(t, int32(1), atomic.LoadInt32(&cs.requests), "not requested again before interval")
+}
+
+func TestHTTPRetryFailedApply(t *testing.T) {
+	var calls int32
+	typemap.MustRegister[dynconf.Callback](context.Background(), "http:retry", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		if data == "changed" && atomic.AddInt32(&calls, 1) == 1 {
+			return fmt.Errorf("apply failed")
+		}
+		return nil
+	}))
+	vs := &versionedServer{}
+	vs.publish("initial")
+	server := httptest.NewServer(vs)
+	defer server.Close()
+
+	newHTTP(t, server, "20ms", "http:retry")
+	vs.publish("changed")
+	assert.Eventuallyf(t, func() bool {
+		return atomic.LoadInt32(&calls) >= 2
+	}, 3*time.Second, 10*time.Millisecond, "the unchanged body applied again after failed")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equalf(t, int32(2), atomic.LoadInt32(&calls), "not applied again after succeeded")
+}
+
+func TestHTTPSnapshot(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "http:snapshot", dynconf.NewRegCallback[bool]("http_snapshot"))
+	dir := t.TempDir()
+	err := dynconf.SetSnapshotDir(dir, 0)
+	assert.Nilf(t, err, "set snapshot dir")
+	defer dynconf.SetSnapshotDir("", 0)
+	for sourceKey, value := range map[string]bool{"http:snapshot": true, "http:orphan": false} {
+		b, _ := json.Marshal(dynconf.Snapshot{
+			SourceKey: sourceKey,
+			Data:      fmt.Sprintf(`{"name": "http_snapshot", "value": %v}`, value),
+			Callbacks: []string{"http:snapshot"},
+			SavedAt:   time.Now(),
+		})
+		err = os.WriteFile(filepath.Join(dir, url.PathEscape(sourceKey)+".json"), b, 0600)
+		assert.Nilf(t, err, "write snapshot")
+	}
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close() // NOTE: the server is unreachable
+
+	h := &dynhttp.HTTP{}
+	err = json.Unmarshal([]byte(fmt.Sprintf(`{
+		"interval": "1h",
+		"datas": [
+			{
+				"url": "%s/snapshot",
+				"source_key": "http:snapshot",
+				"callbacks": ["http:snapshot"]
+			}
+		]
+	}`, server.URL)), h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	err = h.Provision(caddyCtx)
+	assert.Nilf(t, err, "server unreachable")
+	defer h.Cleanup()
+	value, err := typemap.Get[bool](ctx, "http_snapshot")
+	assert.Nilf(t, err, "snapshot applied")
+	assert.Truef(t, value, "snapshot of owned source key applied")
+	_, ok := dynconf.Freshnesses()["http:orphan"]
+	assert.Falsef(t, ok, "orphan snapshot not applied")
+	assert.Nilf(t, h.Ready(), "ready after snapshot applied")
+}
+
+func TestHTTPNotFound(t *testing.T) {
+	var received int32
+	typemap.MustRegister[dynconf.Callback](context.Background(), "http:not_found", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		atomic.AddInt32(&received, 1)
+		return nil
+	}))
+	dir := t.TempDir()
+	err := dynconf.SetSnapshotDir(dir, 0)
+	assert.Nilf(t, err, "set snapshot dir")
+	defer dynconf.SetSnapshotDir("", 0)
+	b, _ := json.Marshal(dynconf.Snapshot{
+		SourceKey: "http:not_found",
+		Data:      "deleted",
+		Callbacks: []string{"http:not_found"},
+		SavedAt:   time.Now(),
+	})
+	err = os.WriteFile(filepath.Join(dir, url.PathEscape("http:not_found")+".json"), b, 0600)
+	assert.Nilf(t, err, "write snapshot")
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	h := newHTTP(t, server, "1h", "http:not_found")
+	assert.Nilf(t, h.Ready(), "url not found considered delivered")
+	assert.Equalf(t, int32(0), atomic.LoadInt32(&received), "nothing delivered, nor the snapshot")
+}
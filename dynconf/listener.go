@@ -0,0 +1,212 @@
+package dynconf
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/ccmonky/typemap"
+)
+
+// ListenerOptions is embedded by listeners for the common options, and tracks the sourceKeys delivered by the listener
+// instance, i.e. the initial data or the snapshot applied successfully, used to implement readiness check.
+//
+// NOTE: listeners only log the failures of fetching the initial data from config center, so that the defaults(or
+// snapshots) keep working when config center is unavailable, see `Dynconf.Ready`
+type ListenerOptions struct {
+	// CallbackTimeout is the timeout of executing each callback, overrides the default timeout of `Callbacks`
+	CallbackTimeout caddy.Duration `json:"callback_timeout,omitempty"`
+
+	deliveries  *deliveries
+	scope       uint64    // the scope of throttlers, see `withThrottleScope`
+	settings    *settings // the settings of the config provisioning the listener, see `Dynconf.Provision`
+	connections *connectionSet
+}
+
+type deliveries struct {
+	sync.RWMutex
+	keys map[string]string // sourceKey -> source, see `Freshness`
+}
+
+type connectionSet struct {
+	sync.Mutex
+	set map[connection]struct{}
+}
+
+// optionsInit guards the lazy initialization of ListenerOptions.deliveries, ListenerOptions.scope,
+// ListenerOptions.settings and ListenerOptions.connections
+var optionsInit sync.Mutex
+
+// CallbackContext returns ctx with CallbackTimeout(see `WithCallbackTimeout`) and the settings of the config
+// provisioning this listener instance, and the data delayed by throttles is tracked by this listener instance, see
+// `FlushThrottles`
+//
+// NOTE: the settings are captured from the first ctx carrying them, i.e. the caddy context passed to Provision
+func (o *ListenerOptions) CallbackContext(ctx context.Context) context.Context {
+	ctx = withSettings(ctx, o.getSettings(ctx))
+	return WithCallbackTimeout(withThrottleScope(ctx, o.getScope()), time.Duration(o.CallbackTimeout))
+}
+
+// Execute executes callbacks with CallbackTimeout and marks sourceKey as delivered once applied, see `Execute`
+func (o *ListenerOptions) Execute(ctx context.Context, callbacks []typemap.Ref[Callback], sourceKey, data string) error {
+	ctx = o.CallbackContext(ctx)
+	return execute(ctx, callbacks, sourceKey, data, func() {
+		markApplied(ctx, sourceKey, data, callbacks)
+		o.deliver(SourceLive, sourceKey)
+	})
+}
+
+// FlushThrottles applies the data delayed by throttles of this listener instance immediately, and waits until applied,
+// see `FlushThrottles`
+func (o *ListenerOptions) FlushThrottles() {
+	scope := o.getScope()
+	flushThrottles(func(tk throttleKey) bool {
+		return tk.scope == scope
+	})
+}
+
+// ExecuteBatch executes a batch with CallbackTimeout and marks the sourceKeys as delivered once applied, see `ExecuteBatch`
+func (o *ListenerOptions) ExecuteBatch(ctx context.Context, changes []Change) error {
+	return executeBatch(o.CallbackContext(ctx), changes, func(change Change) {
+		o.deliver(SourceLive, change.SourceKey)
+	})
+}
+
+// ApplySnapshots applies the snapshots of sourceKeys and marks the applied ones as delivered, see `ApplySnapshots`
+func (o *ListenerOptions) ApplySnapshots(ctx context.Context, sourceKeys ...string) {
+	o.deliver(SourceSnapshot, ApplySnapshots(o.CallbackContext(ctx), sourceKeys...)...)
+}
+
+// ApplySnapshotsPrefix applies the snapshots of sourceKeys with prefix and marks the applied ones as delivered
+func (o *ListenerOptions) ApplySnapshotsPrefix(ctx context.Context, prefix string) {
+	o.deliver(SourceSnapshot, ApplySnapshotsPrefix(o.CallbackContext(ctx), prefix)...)
+}
+
+// MarkDelivered marks key as delivered, used when the initial data is loaded without any sourceKey, e.g. empty prefix
+func (o *ListenerOptions) MarkDelivered(key string) {
+	o.deliver(SourceLive, key)
+}
+
+// Delivered reports whether sourceKey has been delivered by this listener instance, the data from a stale snapshot
+// is not considered as delivered, see `SetSnapshotDir`
+func (o *ListenerOptions) Delivered(sourceKey string) bool {
+	d := o.getDeliveries()
+	d.RLock()
+	source, ok := d.keys[sourceKey]
+	d.RUnlock()
+	if !ok || source == SourceLive {
+		return ok
+	}
+	maxAge := settingsOf(o.CallbackContext(context.Background())).snapshotMaxAge
+	return !freshnesses(maxAge)[sourceKey].Stale
+}
+
+// DeliveredPrefix reports whether any sourceKey with prefix has been delivered by this listener instance, see `Delivered`
+func (o *ListenerOptions) DeliveredPrefix(prefix string) bool {
+	d := o.getDeliveries()
+	d.RLock()
+	var sourceKeys []string
+	for sourceKey := range d.keys {
+		if strings.HasPrefix(sourceKey, prefix) {
+			sourceKeys = append(sourceKeys, sourceKey)
+		}
+	}
+	d.RUnlock()
+	for _, sourceKey := range sourceKeys {
+		if o.Delivered(sourceKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *ListenerOptions) deliver(source string, sourceKeys ...string) {
+	d := o.getDeliveries()
+	d.Lock()
+	defer d.Unlock()
+	for _, sourceKey := range sourceKeys {
+		if source == SourceSnapshot && d.keys[sourceKey] == SourceLive {
+			continue
+		}
+		d.keys[sourceKey] = source
+	}
+}
+
+func (o *ListenerOptions) getDeliveries() *deliveries {
+	optionsInit.Lock()
+	defer optionsInit.Unlock()
+	if o.deliveries == nil {
+		o.deliveries = &deliveries{keys: make(map[string]string)}
+	}
+	return o.deliveries
+}
+
+// SetConnected set the connection state of listener to target as metrics, see `SetListenerConnected`, the state is
+// removed by `DeleteConnected`
+func (o *ListenerOptions) SetConnected(listener, target string, connected bool) {
+	c := o.getConnections()
+	k := connection{listener: listener, target: target}
+	c.Lock()
+	if _, ok := c.set[k]; !ok {
+		c.set[k] = struct{}{}
+		acquireConnection(k)
+	}
+	c.Unlock()
+	SetListenerConnected(listener, target, connected)
+}
+
+// DeleteConnected removes the connection states set by this listener instance, unless they are also set by other
+// instances(e.g. the new instance on config reload), used by listeners on cleanup
+func (o *ListenerOptions) DeleteConnected() {
+	c := o.getConnections()
+	c.Lock()
+	defer c.Unlock()
+	for k := range c.set {
+		releaseConnection(k)
+	}
+	c.set = make(map[connection]struct{})
+}
+
+func (o *ListenerOptions) getConnections() *connectionSet {
+	optionsInit.Lock()
+	defer optionsInit.Unlock()
+	if o.connections == nil {
+		o.connections = &connectionSet{set: make(map[connection]struct{})}
+	}
+	return o.connections
+}
+
+// getSettings returns the settings captured, or captures the settings carried by ctx
+func (o *ListenerOptions) getSettings(ctx context.Context) *settings {
+	optionsInit.Lock()
+	defer optionsInit.Unlock()
+	if o.settings == nil {
+		o.settings = settingsFrom(ctx)
+	}
+	return o.settings
+}
+
+// bindSettings binds the listener instance to s if not bound yet, see `Dynconf.Provision`
+func (o *ListenerOptions) bindSettings(s *settings) {
+	o.getSettings(withSettings(context.Background(), s))
+}
+
+func (o *ListenerOptions) getScope() uint64 {
+	optionsInit.Lock()
+	defer optionsInit.Unlock()
+	if o.scope == 0 {
+		o.scope = atomic.AddUint64(&nextThrottleScope, 1)
+	}
+	return o.scope
+}
+
+// DataOptions is embedded by the data of listeners for the common options of each data
+type DataOptions struct {
+	// Format of the content, available values are: `json`(default), `yaml`, `toml` and `properties`, see `ToJSON`,
+	// the format of callbacks is ignored for the converted data, NOTE: listeners converting the content by themselves
+	// should execute callbacks with `WithSourceFormat`
+	Format Format `json:"format,omitempty"`
+}
@@ -0,0 +1,56 @@
+package dynconf_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenerOptionsDelivered(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "listener:degrade", dynconf.NewRegCallback[bool]("listener_degrade"))
+	callbacks := []typemap.Ref[dynconf.Callback]{{Name: "listener:degrade"}}
+
+	var first, second dynconf.ListenerOptions
+	err := first.Execute(ctx, callbacks, "listener/live", `{"name": "listener_degrade", "value": true}`)
+	assert.Nilf(t, err, "execute")
+	assert.Truef(t, first.Delivered("listener/live"), "delivered")
+	assert.Falsef(t, second.Delivered("listener/live"), "not delivered by another instance")
+	err = first.Execute(ctx, callbacks, "listener/invalid", `{"name": "listener_degrade", "value": "yes"}`)
+	assert.NotNilf(t, err, "invalid")
+	assert.Falsef(t, first.Delivered("listener/invalid"), "invalid data not delivered")
+
+	dir := t.TempDir()
+	err = dynconf.SetSnapshotDir(dir, time.Minute)
+	assert.Nilf(t, err, "set snapshot dir")
+	defer dynconf.SetSnapshotDir("", 0)
+	for sourceKey, savedAt := range map[string]time.Time{"fresh": time.Now(), "stale": time.Now().Add(-time.Hour)} {
+		b, _ := json.Marshal(dynconf.Snapshot{
+			SourceKey: "listener/" + sourceKey,
+			Data:      `{"name": "listener_degrade", "value": false}`,
+			Callbacks: []string{"listener:degrade"},
+			SavedAt:   savedAt,
+		})
+		err = os.WriteFile(filepath.Join(dir, "listener%2F"+sourceKey+".json"), b, 0600)
+		assert.Nilf(t, err, "write snapshot")
+	}
+	second.ApplySnapshots(ctx, "listener/fresh", "listener/stale")
+	assert.Truef(t, second.Delivered("listener/fresh"), "delivered by fresh snapshot")
+	assert.Falsef(t, second.Delivered("listener/stale"), "stale snapshot not delivered")
+	assert.Truef(t, second.DeliveredPrefix("listener/"), "delivered prefix")
+	assert.Falsef(t, first.DeliveredPrefix("listener/f"), "not delivered by another instance")
+	err = second.ExecuteBatch(ctx, []dynconf.Change{{
+		SourceKey: "listener/stale",
+		Data:      `{"name": "listener_degrade", "value": true}`,
+		Callbacks: callbacks,
+	}})
+	assert.Nilf(t, err, "live data")
+	assert.Truef(t, second.Delivered("listener/stale"), "delivered by live data")
+}
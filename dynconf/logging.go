@@ -0,0 +1,126 @@
+package dynconf
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// RedactedValue is used to replace the value of sensitive fields in logs
+const RedactedValue = "******"
+
+// SetLogger set the default logger used by callbacks, NOTE: callbacks executed by a config use the caddy context
+// logger of the config
+func SetLogger(l *zap.Logger) {
+	setDefaults(func(s *settings) { s.logger = l })
+}
+
+// Logger returns the logger used by callbacks executed without context, default is the caddy default logger
+// named `dynconf`
+func Logger() *zap.Logger {
+	return settingsOf(context.Background()).getLogger()
+}
+
+// Redact returns a copy of v(in the form of json value) for logging, the value of struct fields with tag `dynconf:"redact"`
+// are replaced by `RedactedValue`, e.g.
+//
+//	type DB struct {
+//	    User     string `json:"user"`
+//	    Password string `json:"password" dynconf:"redact"`
+//	}
+func Redact(v any) any {
+	if v == nil {
+		return nil
+	}
+	return redact(reflect.ValueOf(v))
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func redact(v reflect.Value) any {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return redact(v.Elem())
+	case reflect.Struct:
+		m := make(map[string]any)
+		redactStruct(v, m)
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 { // NOTE: []byte is encoded as base64 string by json
+			return v.Interface()
+		}
+		s := make([]any, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			s[i] = redact(v.Index(i))
+		}
+		return s
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = redact(iter.Value())
+		}
+		return m
+	default:
+		return v.Interface()
+	}
+}
+
+func redactStruct(v reflect.Value, m map[string]any) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonTag := field.Tag.Get("json")
+		if jsonTag == "-" {
+			continue
+		}
+		name := strings.Split(jsonTag, ",")[0]
+		fv := v.Field(i)
+		if field.Anonymous && name == "" {
+			for fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					break
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				redactStruct(fv, m)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if field.Tag.Get("dynconf") == "redact" {
+			m[name] = RedactedValue
+			continue
+		}
+		m[name] = redact(fv)
+	}
+}
@@ -0,0 +1,81 @@
+package dynconf_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type Credential struct {
+	User     string `json:"user"`
+	Password string `json:"password" dynconf:"redact"`
+}
+
+type Secrets struct {
+	Credential
+	Tokens  map[string]Credential `json:"tokens"`
+	Backups []*Credential         `json:"backups,omitempty"`
+	Expire  time.Duration         `json:"expire"`
+	Ignored string                `json:"-"`
+}
+
+func TestRedact(t *testing.T) {
+	v := Secrets{
+		Credential: Credential{User: "root", Password: "123"},
+		Tokens:     map[string]Credential{"a": {User: "a", Password: "456"}},
+		Backups:    []*Credential{{User: "b", Password: "789"}},
+		Expire:     time.Second,
+		Ignored:    "ignored",
+	}
+	assert.Equalf(t, map[string]any{
+		"user":     "root",
+		"password": dynconf.RedactedValue,
+		"tokens": map[string]any{
+			"a": map[string]any{"user": "a", "password": dynconf.RedactedValue},
+		},
+		"backups": []any{
+			map[string]any{"user": "b", "password": dynconf.RedactedValue},
+		},
+		"expire": time.Second,
+	}, dynconf.Redact(v), "redact")
+	assert.Equalf(t, true, dynconf.Redact(true), "redact bool")
+	assert.Nilf(t, dynconf.Redact(nil), "redact nil")
+}
+
+func TestRegCallbackLog(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	dynconf.SetLogger(zap.New(core))
+	defer dynconf.SetLogger(nil)
+	cb := dynconf.NewRegCallback[Credential]("log_credential")
+	err := cb.Callback("log:credential", `{"name": "log_credential", "value": {"user": "root", "password": "123"}}`)
+	assert.Nilf(t, err, "first time")
+	err = cb.Callback("log:credential", `{"name": "log_credential", "value": {"user": "admin", "password": "456"}}`)
+	assert.Nilf(t, err, "change")
+	err = cb.Callback("log:credential", `{"name": "log_credential", "value": {"user": "root"}}`)
+	assert.NotNilf(t, err, "invalid")
+
+	entries := logs.All()
+	assert.Lenf(t, entries, 3, "logs")
+	first := entries[0].ContextMap()
+	assert.Equalf(t, "log:credential", first["source_key"], "source key")
+	assert.Equalf(t, "log_credential", first["name"], "name")
+	assert.Equalf(t, "register", first["outcome"], "outcome")
+	assert.Equalf(t, map[string]any{"user": "root", "password": dynconf.RedactedValue}, first["new"], "new redacted")
+	second := entries[1].ContextMap()
+	assert.Equalf(t, "set", second["outcome"], "outcome")
+	assert.Equalf(t, []dynconf.Operation{
+		{Op: "replace", Path: "/user", Value: "admin", Old: "root"},
+	}, second["diff"], "diff of redacted values")
+	assert.Equalf(t, "invalid", entries[2].ContextMap()["outcome"], "outcome")
+	for _, entry := range entries {
+		for _, v := range entry.ContextMap() {
+			assert.NotContainsf(t, fmt.Sprint(v), "123", "secret leaked")
+			assert.NotContainsf(t, fmt.Sprint(v), "456", "secret leaked")
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package dynconf
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// define and register the metrics of dynconf, which are exported by caddy admin endpoint `/metrics`
+func init() {
+	const ns, sub = "caddy", "dynconf"
+
+	dynconfMetrics.callbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "callbacks_total",
+		Help:      "Counter of callback invocations by callback key and outcome(success, invalid, timeout or failed).",
+	}, []string{"key", "outcome"})
+	dynconfMetrics.duration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "callback_duration_seconds",
+		Help:      "Histogram of latencies of callback invocations by callback key.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 8),
+	}, []string{"key"})
+	dynconfMetrics.validationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "validation_failures_total",
+		Help:      "Counter of invalid data of RegCallbacks by typemap instance name.",
+	}, []string{"name"})
+	dynconfMetrics.throttled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "throttled_total",
+		Help:      "Counter of data delayed or coalesced by throttle by callback key and result(delayed, coalesced or dropped).",
+	}, []string{"key", "result"})
+	dynconfMetrics.lastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Timestamp of the last data applied successfully by source key.",
+	}, []string{"source_key"})
+	dynconfMetrics.listenerConnected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "listener_connected",
+		Help:      "Whether the listener is connected to the config center(1) or not(0) by listener and target.",
+	}, []string{"listener", "target"})
+}
+
+// dynconfMetrics is a collection of metrics of dynconf listeners and callbacks.
+var dynconfMetrics = struct {
+	callbacks          *prometheus.CounterVec
+	duration           *prometheus.HistogramVec
+	validationFailures *prometheus.CounterVec
+	throttled          *prometheus.CounterVec
+	lastSuccess        *prometheus.GaugeVec
+	listenerConnected  *prometheus.GaugeVec
+}{}
+
+// SetListenerConnected set the connection state of listener to target(e.g. the address of config center), used by
+// listeners to expose the state as metrics, see `ListenerOptions.SetConnected`
+func SetListenerConnected(listener, target string, connected bool) {
+	var v float64
+	if connected {
+		v = 1
+	}
+	dynconfMetrics.listenerConnected.WithLabelValues(listener, target).Set(v)
+}
+
+// DeleteListenerConnected removes the connection state of listener to target, used by listeners on cleanup
+func DeleteListenerConnected(listener, target string) {
+	dynconfMetrics.listenerConnected.DeleteLabelValues(listener, target)
+}
+
+type connection struct {
+	listener string
+	target   string
+}
+
+// connections counts the listener instances exposing the connection state of each connection, so that the state is
+// not removed by the old listener instance on config reload
+var connections = struct {
+	sync.Mutex
+	refs map[connection]int
+}{
+	refs: make(map[connection]int),
+}
+
+func acquireConnection(c connection) {
+	connections.Lock()
+	defer connections.Unlock()
+	connections.refs[c]++
+}
+
+func releaseConnection(c connection) {
+	connections.Lock()
+	defer connections.Unlock()
+	connections.refs[c]--
+	if connections.refs[c] > 0 {
+		return
+	}
+	delete(connections.refs, c)
+	DeleteListenerConnected(c.listener, c.target)
+}
+
+// observeCallback records the invocation of callback registered with key started at start
+func observeCallback(key string, start time.Time, err error) {
+	outcome := "success"
+	switch {
+	case err == nil:
+	case IsValidationError(err):
+		outcome = "invalid"
+	case errors.Is(err, context.DeadlineExceeded):
+		outcome = "timeout"
+	default:
+		outcome = "failed"
+	}
+	dynconfMetrics.callbacks.WithLabelValues(key, outcome).Inc()
+	dynconfMetrics.duration.WithLabelValues(key).Observe(time.Since(start).Seconds())
+}
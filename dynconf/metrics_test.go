@@ -0,0 +1,94 @@
+package dynconf_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// metricValue returns the value of counter or gauge name with labels in the default registry
+func metricValue(t *testing.T, name string, labels map[string]string) float64 {
+	v, _ := lookupMetric(t, name, labels)
+	return v
+}
+
+// metricExists reports whether the series of name with labels exists in the default registry
+func metricExists(t *testing.T, name string, labels map[string]string) bool {
+	_, ok := lookupMetric(t, name, labels)
+	return ok
+}
+
+func lookupMetric(t *testing.T, name string, labels map[string]string) (float64, bool) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.Nilf(t, err, "gather")
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if v, ok := labels[label.GetName()]; ok && v != label.GetValue() {
+					continue metrics
+				}
+			}
+			switch {
+			case m.Counter != nil:
+				return m.Counter.GetValue(), true
+			case m.Gauge != nil:
+				return m.Gauge.GetValue(), true
+			case m.Histogram != nil:
+				return float64(m.Histogram.GetSampleCount()), true
+			}
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "metrics:rate_limit", dynconf.NewRegCallback[RateLimit]("metrics_rate_limit"))
+	callbacks := []typemap.Ref[dynconf.Callback]{{Name: "metrics:rate_limit"}}
+	err := dynconf.Execute(ctx, callbacks, "metrics", `{"name": "metrics_rate_limit", "value": {"rate": 1, "burst": 1}}`)
+	assert.Nilf(t, err, "success")
+	err = dynconf.Execute(ctx, callbacks, "metrics", `{"name": "metrics_rate_limit", "value": {"rate": "fast"}}`)
+	assert.NotNilf(t, err, "invalid")
+	err = dynconf.ExecuteBatch(ctx, []dynconf.Change{{
+		SourceKey: "metrics",
+		Data:      `{"name": "metrics_rate_limit", "value": {"rate": "fast"}}`,
+		Callbacks: callbacks,
+	}})
+	assert.NotNilf(t, err, "invalid")
+
+	assert.Equalf(t, 1.0, metricValue(t, "caddy_dynconf_callbacks_total", map[string]string{"key": "metrics:rate_limit", "outcome": "success"}), "success")
+	assert.Equalf(t, 2.0, metricValue(t, "caddy_dynconf_callbacks_total", map[string]string{"key": "metrics:rate_limit", "outcome": "invalid"}), "invalid")
+	assert.Equalf(t, 3.0, metricValue(t, "caddy_dynconf_callback_duration_seconds", map[string]string{"key": "metrics:rate_limit"}), "duration")
+	assert.Equalf(t, 2.0, metricValue(t, "caddy_dynconf_validation_failures_total", map[string]string{"name": "metrics_rate_limit"}), "validation failures")
+	assert.Greaterf(t, metricValue(t, "caddy_dynconf_last_success_timestamp_seconds", map[string]string{"source_key": "metrics"}), 0.0, "last success")
+
+	dynconf.SetListenerConnected("metrics", "127.0.0.1:2379", true)
+	assert.Equalf(t, 1.0, metricValue(t, "caddy_dynconf_listener_connected", map[string]string{"listener": "metrics"}), "connected")
+	dynconf.SetListenerConnected("metrics", "127.0.0.1:2379", false)
+	assert.Equalf(t, 0.0, metricValue(t, "caddy_dynconf_listener_connected", map[string]string{"listener": "metrics"}), "disconnected")
+	dynconf.DeleteListenerConnected("metrics", "127.0.0.1:2379")
+	assert.Falsef(t, metricExists(t, "caddy_dynconf_listener_connected", map[string]string{"listener": "metrics"}), "deleted")
+}
+
+func TestListenerOptionsConnected(t *testing.T) {
+	labels := map[string]string{"listener": "connected", "target": "127.0.0.1:8848"}
+	var old, reloaded dynconf.ListenerOptions
+	old.SetConnected("connected", "127.0.0.1:8848", true)
+	reloaded.SetConnected("connected", "127.0.0.1:8848", false)
+	old.DeleteConnected()
+	assert.Truef(t, metricExists(t, "caddy_dynconf_listener_connected", labels), "kept for the new instance")
+	assert.Equalf(t, 0.0, metricValue(t, "caddy_dynconf_listener_connected", labels), "state of the new instance")
+	reloaded.SetConnected("connected", "127.0.0.1:8848", true)
+	reloaded.DeleteConnected()
+	reloaded.DeleteConnected()
+	assert.Falsef(t, metricExists(t, "caddy_dynconf_listener_connected", labels), "deleted")
+}
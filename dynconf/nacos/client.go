@@ -0,0 +1,227 @@
+package nacos
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wordSeparator   = "\x02"
+	lineSeparator   = "\x01"
+	listeningConfig = "Listening-Configs"
+	pullingTimeout  = "Long-Pulling-Timeout"
+)
+
+// client is a minimal client of nacos open api(v1), only config query, listen and login are implemented
+type client struct {
+	servers     []ServerConfig
+	namespaceId string
+	username    string
+	password    string
+	httpClient  *http.Client
+
+	lock        sync.Mutex
+	current     int
+	accessToken string
+	tokenExpire time.Time
+}
+
+func newClient(servers []ServerConfig, cc ClientConfig) *client {
+	return &client{
+		servers:     servers,
+		namespaceId: cc.NamespaceId,
+		username:    cc.Username,
+		password:    cc.Password,
+		httpClient:  &http.Client{},
+	}
+}
+
+// getConfig query config content of dataId and group, found is false if config not exists
+func (c *client) getConfig(ctx context.Context, dataId, group string) (content string, found bool, err error) {
+	params := url.Values{}
+	params.Set("dataId", dataId)
+	params.Set("group", group)
+	if c.namespaceId != "" {
+		params.Set("tenant", c.namespaceId)
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/v1/cs/configs", params, nil)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return string(body), true, nil
+	case http.StatusNotFound:
+		return "", false, nil
+	default:
+		return "", false, fmt.Errorf("get config %s:%s failed: status=%d, body=%s", group, dataId, resp.StatusCode, string(body))
+	}
+}
+
+// listen long polls the server until any of the datas changed or timeout, returns the changed datas
+func (c *client) listen(ctx context.Context, datas []*NacosData, timeout time.Duration) ([]*NacosData, error) {
+	var b strings.Builder
+	for _, data := range datas {
+		b.WriteString(data.DataId + wordSeparator + data.Group + wordSeparator + data.md5)
+		if c.namespaceId != "" {
+			b.WriteString(wordSeparator + c.namespaceId)
+		}
+		b.WriteString(lineSeparator)
+	}
+	form := url.Values{}
+	form.Set(listeningConfig, b.String())
+	header := http.Header{}
+	header.Set(pullingTimeout, strconv.FormatInt(timeout.Milliseconds(), 10))
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx, cancel := context.WithTimeout(ctx, timeout+10*time.Second)
+	defer cancel()
+	resp, err := c.do(ctx, http.MethodPost, "/v1/cs/configs/listener", nil, &request{header: header, body: form.Encode()})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listen configs failed: status=%d, body=%s", resp.StatusCode, string(body))
+	}
+	decoded, err := url.QueryUnescape(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("decode listen result %s failed: %v", string(body), err)
+	}
+	var changed []*NacosData
+	for _, line := range strings.Split(decoded, lineSeparator) {
+		words := strings.Split(line, wordSeparator)
+		if len(words) < 2 {
+			continue
+		}
+		for _, data := range datas {
+			if data.DataId == words[0] && data.Group == words[1] {
+				changed = append(changed, data)
+			}
+		}
+	}
+	return changed, nil
+}
+
+type request struct {
+	header http.Header
+	body   string
+}
+
+// do sends request to current server, switches to next server if failed
+func (c *client) do(ctx context.Context, method, path string, params url.Values, req *request) (*http.Response, error) {
+	c.lock.Lock()
+	server := c.servers[c.current]
+	c.lock.Unlock()
+	if params == nil {
+		params = url.Values{}
+	}
+	token, err := c.token(ctx, server)
+	if err != nil {
+		c.next()
+		return nil, err
+	}
+	if token != "" {
+		params.Set("accessToken", token)
+	}
+	u := server.baseURL() + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	var body io.Reader
+	if req != nil {
+		body = strings.NewReader(req.body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if req != nil {
+		for k, vs := range req.header {
+			httpReq.Header[k] = vs
+		}
+	}
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		c.next()
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		c.next()
+	}
+	return resp, nil
+}
+
+func (c *client) next() {
+	c.lock.Lock()
+	c.current = (c.current + 1) % len(c.servers)
+	c.lock.Unlock()
+}
+
+// token returns the access token, login again if expired, empty if username not specified
+func (c *client) token(ctx context.Context, server ServerConfig) (string, error) {
+	if c.username == "" {
+		return "", nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.accessToken != "" && time.Now().Before(c.tokenExpire) {
+		return c.accessToken, nil
+	}
+	form := url.Values{}
+	form.Set("username", c.username)
+	form.Set("password", c.password)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.baseURL()+"/v1/auth/login", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("nacos login failed: status=%d, body=%s", resp.StatusCode, string(body))
+	}
+	var result struct {
+		AccessToken string `json:"accessToken"`
+		TokenTtl    int64  `json:"tokenTtl"`
+	}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return "", fmt.Errorf("unmarshal nacos login result %s failed: %v", string(body), err)
+	}
+	c.accessToken = result.AccessToken
+	// NOTE: refresh token before it really expires
+	c.tokenExpire = time.Now().Add(time.Duration(result.TokenTtl) * time.Second * 9 / 10)
+	return c.accessToken, nil
+}
+
+// md5Hex returns the md5 of content, NOTE: the md5 of empty content is not empty, which means the config not found
+func md5Hex(content string) string {
+	sum := md5.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
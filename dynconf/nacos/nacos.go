@@ -1,33 +1,324 @@
 package nacos
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/caddyserver/caddy/v2"
 	"github.com/ccmonky/caddy-config/dynconf"
 	"github.com/ccmonky/typemap"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(Nacos{})
+}
+
+const (
+	DefaultGroup           = "DEFAULT_GROUP"
+	DefaultContextPath     = "/nacos"
+	DefaultTimeout         = 10 * time.Second
+	DefaultLongPollTimeout = 30 * time.Second
+	DefaultRetryInterval   = time.Second
 )
 
-// Not implemnt...
+// Nacos listen configs on nacos servers(via nacos open api), and execute the referenced callbacks
+// with the initial value and every change of each data, datas changed at the same time and sharing
+// callbacks are executed as a batch(see `dynconf.ExecuteBatch`)
 type Nacos struct {
-	ServerConfig string      `json:"server_config"`
-	ClientConfig string      `json:"client_config"`
-	Datas        []NacosData `json:"datas"`
+	ServerConfigs []ServerConfig `json:"server_configs"`
+	ClientConfig  ClientConfig   `json:"client_config,omitempty"`
+	Datas         []NacosData    `json:"datas"`
+	dynconf.ListenerOptions
+
+	client *client
+	logger *zap.Logger
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// ServerConfig define the address of a nacos server
+type ServerConfig struct {
+	// Scheme default is `http`
+	Scheme string `json:"scheme,omitempty"`
+	IpAddr string `json:"ip_addr"`
+	Port   uint64 `json:"port"`
+	// ContextPath default is `/nacos`
+	ContextPath string `json:"context_path,omitempty"`
 }
 
+func (sc ServerConfig) baseURL() string {
+	scheme := sc.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	contextPath := sc.ContextPath
+	if contextPath == "" {
+		contextPath = DefaultContextPath
+	}
+	return scheme + "://" + net.JoinHostPort(sc.IpAddr, strconv.FormatUint(sc.Port, 10)) + "/" + strings.Trim(contextPath, "/")
+}
+
+// ClientConfig define the options of nacos client
+type ClientConfig struct {
+	NamespaceId string `json:"namespace_id,omitempty"`
+	Username    string `json:"username,omitempty"`
+	Password    string `json:"password,omitempty"`
+	// Timeout used to query config, default is 10s
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+	// LongPollTimeout used to listen configs, default is 30s
+	LongPollTimeout caddy.Duration `json:"long_poll_timeout,omitempty"`
+	// RetryInterval used to wait before listening again when failed, default is 1s
+	RetryInterval caddy.Duration `json:"retry_interval,omitempty"`
+}
+
+// NacosData define a nacos config to listen and the callbacks to execute with its content
 type NacosData struct {
-	Group     string                          `json:"group"`
-	DataId    string                          `json:"data_id"`
+	// Group default is `DEFAULT_GROUP`
+	Group  string `json:"group"`
+	DataId string `json:"data_id"`
+	dynconf.DataOptions
 	Callbacks []typemap.Ref[dynconf.Callback] `json:"callbacks"`
+
+	md5 string // md5 of the content applied successfully, empty if not loaded, i.e. not found or failed
+}
+
+// SourceKey returns the source key passed to callbacks, i.e. `group:data_id`
+func (nd *NacosData) SourceKey() string {
+	return nd.Group + ":" + nd.DataId
 }
 
 func (n Nacos) ID() string {
 	return "config.ext.dynconf.listeners.nacos"
 }
 
+// CaddyModule returns the Caddy module information.
+func (n Nacos) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  caddy.ModuleID(n.ID()),
+		New: func() caddy.Module { return new(Nacos) },
+	}
+}
+
+// Provision implement caddy.Provisioner, execute callbacks with the initial value of datas and start listening
 func (n *Nacos) Provision(ctx caddy.Context) error {
+	n.logger = ctx.Logger(n)
+	if len(n.ServerConfigs) == 0 {
+		return fmt.Errorf("%s: server_configs is empty", n.ID())
+	}
+	if n.ClientConfig.Timeout <= 0 {
+		n.ClientConfig.Timeout = caddy.Duration(DefaultTimeout)
+	}
+	if n.ClientConfig.LongPollTimeout <= 0 {
+		n.ClientConfig.LongPollTimeout = caddy.Duration(DefaultLongPollTimeout)
+	}
+	if n.ClientConfig.RetryInterval <= 0 {
+		n.ClientConfig.RetryInterval = caddy.Duration(DefaultRetryInterval)
+	}
+	datas := make([]*NacosData, 0, len(n.Datas))
+	for i := range n.Datas {
+		data := &n.Datas[i]
+		if data.DataId == "" {
+			return fmt.Errorf("%s: datas[%d] data_id is empty", n.ID(), i)
+		}
+		if err := data.Format.Validate(); err != nil {
+			return fmt.Errorf("%s: datas[%d] %v", n.ID(), i, err)
+		}
+		if data.Group == "" {
+			data.Group = DefaultGroup
+		}
+		datas = append(datas, data)
+	}
+	n.client = newClient(n.ServerConfigs, n.ClientConfig)
+	unfetched, err := n.refresh(ctx, datas)
+	if err != nil {
+		return fmt.Errorf("%s: execute callbacks with initial values failed: %v", n.ID(), err)
+	}
+	// NOTE: only the datas failed to query fall back to snapshots, the ones not found may have been deleted on purpose
+	if len(unfetched) > 0 {
+		n.ApplySnapshots(ctx, unfetched...)
+	}
+	listenCtx, cancel := context.WithCancel(context.Background())
+	n.cancel = cancel
+	n.done = make(chan struct{})
+	go n.listen(listenCtx, datas)
 	return nil
 }
 
+// Cleanup implement caddy.CleanerUpper, stop listening
+func (n *Nacos) Cleanup() error {
+	if n.cancel != nil {
+		n.cancel()
+		<-n.done
+	}
+	n.DeleteConnected()
+	return nil
+}
+
+func (n *Nacos) listen(ctx context.Context, datas []*NacosData) {
+	defer close(n.done)
+	for {
+		changed, err := n.client.listen(ctx, datas, time.Duration(n.ClientConfig.LongPollTimeout))
+		if ctx.Err() != nil {
+			return
+		}
+		n.SetConnected(n.ID(), n.target(), err == nil)
+		if err != nil {
+			n.logger.Warn("listen nacos configs failed", zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(n.ClientConfig.RetryInterval)):
+			}
+			continue
+		}
+		unfetched, err := n.refresh(ctx, changed)
+		if err != nil {
+			n.logger.Error("execute callbacks failed", zap.Error(err))
+		}
+		// NOTE: the md5 of failed datas is not updated, so the next listen returns immediately, wait to avoid busy loop
+		if len(unfetched) > 0 || err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(n.ClientConfig.RetryInterval)):
+			}
+		}
+	}
+}
+
+// Ready implement dynconf.Readier, returns error until the initial data of each data id has been delivered, a data id
+// not found is considered delivered, since there is nothing to wait for until it is published
+func (n *Nacos) Ready() error {
+	var pendings []string
+	for i := range n.Datas {
+		if sourceKey := n.Datas[i].SourceKey(); !n.Delivered(sourceKey) {
+			pendings = append(pendings, sourceKey)
+		}
+	}
+	if len(pendings) > 0 {
+		return fmt.Errorf("%s: initial data of %s not delivered", n.ID(), strings.Join(pendings, ", "))
+	}
+	return nil
+}
+
+// target returns the addresses of nacos servers, used as the label of metrics
+func (n *Nacos) target() string {
+	urls := make([]string, 0, len(n.ServerConfigs))
+	for _, sc := range n.ServerConfigs {
+		urls = append(urls, sc.baseURL())
+	}
+	return strings.Join(urls, ",")
+}
+
+// refresh query the content of datas and execute callbacks of the changed ones, changes sharing callbacks are executed
+// as a batch(see `batches`), so that an invalid data only blocks the datas updated together with it. Failing to query
+// is only logged and the source keys of them are returned as unfetched, the md5 of datas is updated only if their batch
+// succeeded, so that the failed ones are queried again
+func (n *Nacos) refresh(ctx context.Context, datas []*NacosData) (unfetched []string, err error) {
+	var changed []*NacosData
+	contents := make(map[*NacosData]string)
+	md5s := make(map[*NacosData]string)
+	for _, data := range datas {
+		content, found, err := n.fetch(ctx, data)
+		if err != nil {
+			unfetched = append(unfetched, data.SourceKey())
+			continue
+		}
+		if !found {
+			// NOTE: the data_id not published yet is considered delivered, see `Ready`
+			data.md5 = ""
+			n.MarkDelivered(data.SourceKey())
+			continue
+		}
+		if md5 := md5Hex(content); md5 != data.md5 {
+			changed = append(changed, data)
+			contents[data] = content
+			md5s[data] = md5
+		}
+	}
+	var errs error
+	for _, batch := range batches(changed) {
+		changes := make([]dynconf.Change, 0, len(batch))
+		for _, data := range batch {
+			changes = append(changes, dynconf.Change{
+				SourceKey: data.SourceKey(),
+				Data:      contents[data],
+				Format:    data.Format,
+				Callbacks: data.Callbacks,
+			})
+		}
+		if err := n.ExecuteBatch(ctx, changes); err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		for _, data := range batch {
+			data.md5 = md5s[data]
+		}
+	}
+	return unfetched, errs
+}
+
+// batches groups datas sharing any callback(directly or via other datas), keeping the order of datas in each group
+func batches(datas []*NacosData) [][]*NacosData {
+	parents := make([]int, len(datas))
+	var root func(i int) int
+	root = func(i int) int {
+		for parents[i] != i {
+			i = parents[i]
+		}
+		return i
+	}
+	owners := make(map[string]int) // callback name -> index of the first data referencing it
+	for i, data := range datas {
+		parents[i] = i
+		for k := range data.Callbacks {
+			name := data.Callbacks[k].Name
+			if j, ok := owners[name]; ok {
+				parents[root(i)] = root(j)
+				continue
+			}
+			owners[name] = i
+		}
+	}
+	var groups [][]*NacosData
+	indexes := make(map[int]int) // root -> index of group
+	for i, data := range datas {
+		r := root(i)
+		g, ok := indexes[r]
+		if !ok {
+			g = len(groups)
+			indexes[r] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], data)
+	}
+	return groups
+}
+
+// fetch query the content of data, failing to query is logged
+func (n *Nacos) fetch(ctx context.Context, data *NacosData) (content string, found bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(n.ClientConfig.Timeout))
+	defer cancel()
+	content, found, err = n.client.getConfig(ctx, data.DataId, data.Group)
+	if err != nil {
+		n.logger.Warn("get nacos config failed", zap.String("source_key", data.SourceKey()), zap.Error(err))
+		return "", false, err
+	}
+	if !found {
+		n.logger.Warn("nacos config not found", zap.String("source_key", data.SourceKey()))
+	}
+	return content, found, nil
+}
+
 // Interface guard
 var (
-	_ caddy.Provisioner = (*Nacos)(nil)
+	_ caddy.Provisioner  = (*Nacos)(nil)
+	_ caddy.CleanerUpper = (*Nacos)(nil)
+	_ dynconf.Readier    = (*Nacos)(nil)
 )
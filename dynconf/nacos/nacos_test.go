@@ -0,0 +1,412 @@
+package nacos_test
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/caddy-config/dynconf/nacos"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNacos is an in-process stand-in of nacos config open api
+type fakeNacos struct {
+	lock     sync.Mutex
+	configs  map[string]string // group:data_id -> content
+	changed  chan struct{}
+	failGets bool
+	listens  int
+}
+
+func newFakeNacos() *fakeNacos {
+	return &fakeNacos{
+		configs: make(map[string]string),
+		changed: make(chan struct{}),
+	}
+}
+
+func (f *fakeNacos) publish(group, dataId, content string) {
+	f.lock.Lock()
+	f.configs[group+":"+dataId] = content
+	close(f.changed)
+	f.changed = make(chan struct{})
+	f.lock.Unlock()
+}
+
+func (f *fakeNacos) setFailGets(fail bool) {
+	f.lock.Lock()
+	f.failGets = fail
+	f.lock.Unlock()
+}
+
+func (f *fakeNacos) getListens() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.listens
+}
+
+// md5 returns the md5 of config, empty if not found
+func (f *fakeNacos) md5(key string) string {
+	content, ok := f.configs[key]
+	if !ok {
+		return ""
+	}
+	return md5Hex(content)
+}
+
+func (f *fakeNacos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/nacos/v1/cs/configs":
+		f.lock.Lock()
+		content, ok := f.configs[r.URL.Query().Get("group")+":"+r.URL.Query().Get("dataId")]
+		failGets := f.failGets
+		f.lock.Unlock()
+		if failGets {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, content)
+	case "/nacos/v1/cs/configs/listener":
+		timeout, _ := time.ParseDuration(r.Header.Get("Long-Pulling-Timeout") + "ms")
+		deadline := time.After(timeout)
+		f.lock.Lock()
+		f.listens++
+		f.lock.Unlock()
+		for {
+			f.lock.Lock()
+			var changed []string
+			for _, line := range strings.Split(r.FormValue("Listening-Configs"), "\x01") {
+				words := strings.Split(line, "\x02")
+				if len(words) < 3 {
+					continue
+				}
+				if f.md5(words[1]+":"+words[0]) != words[2] {
+					changed = append(changed, words[0]+"\x02"+words[1]+"\x01")
+				}
+			}
+			ch := f.changed
+			f.lock.Unlock()
+			if len(changed) > 0 {
+				fmt.Fprint(w, url.QueryEscape(strings.Join(changed, "")))
+				return
+			}
+			select {
+			case <-ch:
+			case <-deadline:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func md5Hex(content string) string {
+	sum := md5.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestNacos(t *testing.T) {
+	ctx := context.Background()
+	var lock sync.Mutex
+	var received []string
+	typemap.MustRegister[dynconf.Callback](ctx, "nacos:switch", dynconf.NewRegCallback[bool]("nacos_switch"))
+	typemap.MustRegister[dynconf.Callback](ctx, "nacos:switch:record", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		lock.Lock()
+		received = append(received, sourceKey)
+		lock.Unlock()
+		return nil
+	}))
+
+	fake := newFakeNacos()
+	fake.publish("nacos", "switch", `{"name": "nacos_switch", "value": true}`)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	n := &nacos.Nacos{}
+	err := json.Unmarshal([]byte(fmt.Sprintf(`{
+		"server_configs": [
+			{
+				"ip_addr": "%s",
+				"port": %s
+			}
+		],
+		"client_config": {
+			"long_poll_timeout": "1s"
+		},
+		"datas": [
+			{
+				"group": "nacos",
+				"data_id": "switch",
+				"callbacks": ["nacos:switch", "nacos:switch:record"]
+			}
+		]
+	}`, host, port)), n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	err = n.Provision(caddyCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Cleanup()
+	assert.Nilf(t, n.Ready(), "ready after initial data delivered")
+	value, err := typemap.Get[bool](ctx, "nacos_switch")
+	assert.Nilf(t, err, "initial value")
+	assert.Truef(t, value, "initial value")
+
+	fake.publish("nacos", "switch", `{"name": "nacos_switch", "value": false}`)
+	assert.Eventuallyf(t, func() bool {
+		value, err := typemap.Get[bool](ctx, "nacos_switch")
+		return err == nil && !value
+	}, 3*time.Second, 10*time.Millisecond, "changed value")
+	lock.Lock()
+	assert.Equalf(t, []string{"nacos:switch", "nacos:switch"}, received, "sourceKey")
+	lock.Unlock()
+
+	err = n.Cleanup()
+	assert.Nilf(t, err, "cleanup")
+	fake.publish("nacos", "switch", `{"name": "nacos_switch", "value": true}`)
+	time.Sleep(100 * time.Millisecond)
+	value, _ = typemap.Get[bool](ctx, "nacos_switch")
+	assert.Falsef(t, value, "should not change after cleanup")
+}
+
+func newNacos(t *testing.T, server *httptest.Server, dataId string, callbacks ...string) *nacos.Nacos {
+	refs, _ := json.Marshal(callbacks)
+	return newNacosDatas(t, server, fmt.Sprintf(`[
+		{
+			"group": "nacos",
+			"data_id": "%s",
+			"callbacks": %s
+		}
+	]`, dataId, refs))
+}
+
+func newNacosDatas(t *testing.T, server *httptest.Server, datas string) *nacos.Nacos {
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	n := &nacos.Nacos{}
+	err := json.Unmarshal([]byte(fmt.Sprintf(`{
+		"server_configs": [
+			{
+				"ip_addr": "%s",
+				"port": %s
+			}
+		],
+		"client_config": {
+			"long_poll_timeout": "1s",
+			"retry_interval": "100ms"
+		},
+		"datas": %s
+	}`, host, port, datas)), n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	t.Cleanup(cancel)
+	err = n.Provision(caddyCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Cleanup() })
+	return n
+}
+
+func TestNacosEmptyConfig(t *testing.T) {
+	var lock sync.Mutex
+	var received []string
+	typemap.MustRegister[dynconf.Callback](context.Background(), "nacos:empty", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		lock.Lock()
+		received = append(received, data)
+		lock.Unlock()
+		return nil
+	}))
+
+	fake := newFakeNacos()
+	fake.publish("nacos", "empty", "")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	n := newNacos(t, server, "empty", "nacos:empty")
+	assert.Nilf(t, n.Ready(), "empty config delivered")
+	time.Sleep(100 * time.Millisecond)
+	lock.Lock()
+	assert.Equalf(t, []string{""}, received, "delivered once")
+	lock.Unlock()
+}
+
+func TestNacosRetryFailedFetch(t *testing.T) {
+	var lock sync.Mutex
+	var latest string
+	typemap.MustRegister[dynconf.Callback](context.Background(), "nacos:retry", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		lock.Lock()
+		latest = data
+		lock.Unlock()
+		return nil
+	}))
+
+	fake := newFakeNacos()
+	fake.publish("nacos", "retry", `{"name": "nacos_retry", "value": true}`)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	newNacos(t, server, "retry", "nacos:retry")
+	fake.setFailGets(true)
+	fake.publish("nacos", "retry", `{"name": "nacos_retry", "value": false}`)
+	time.Sleep(500 * time.Millisecond)
+	listens := fake.getListens()
+	assert.LessOrEqualf(t, listens, 8, "wait retry interval after failing to fetch changed data")
+
+	fake.setFailGets(false)
+	assert.Eventuallyf(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return latest == `{"name": "nacos_retry", "value": false}`
+	}, 3*time.Second, 10*time.Millisecond, "changed value fetched again")
+}
+
+func TestNacosThrottle(t *testing.T) {
+	var lock sync.Mutex
+	var received []string
+	typemap.MustRegister[dynconf.Callback](context.Background(), "nacos:throttle", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		lock.Lock()
+		received = append(received, data)
+		lock.Unlock()
+		return nil
+	}))
+	dynconf.SetThrottle("nacos:throttle", dynconf.Throttle{Debounce: caddy.Duration(300 * time.Millisecond)})
+	defer dynconf.SetThrottle("nacos:throttle", dynconf.Throttle{})
+
+	fake := newFakeNacos()
+	fake.publish("nacos", "throttle", "0")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	n := newNacos(t, server, "throttle", "nacos:throttle")
+	assert.Nilf(t, n.Ready(), "the initial data applied immediately")
+	for i := 1; i <= 3; i++ {
+		fake.publish("nacos", "throttle", fmt.Sprint(i))
+		time.Sleep(20 * time.Millisecond)
+	}
+	lock.Lock()
+	assert.Equalf(t, []string{"0"}, received, "changes delayed")
+	lock.Unlock()
+	assert.Eventuallyf(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(received) == 2 && received[1] == "3"
+	}, 3*time.Second, 10*time.Millisecond, "changes coalesced")
+}
+
+func TestNacosNotFound(t *testing.T) {
+	var lock sync.Mutex
+	var received []string
+	typemap.MustRegister[dynconf.Callback](context.Background(), "nacos:not_found", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		lock.Lock()
+		received = append(received, data)
+		lock.Unlock()
+		return nil
+	}))
+
+	fake := newFakeNacos()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	n := newNacos(t, server, "not_found", "nacos:not_found")
+	assert.Nilf(t, n.Ready(), "data id not found considered delivered")
+	fake.publish("nacos", "not_found", "published")
+	assert.Eventuallyf(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(received) == 1 && received[0] == "published"
+	}, 3*time.Second, 10*time.Millisecond, "delivered once published")
+}
+
+func TestNacosInvalidDataNotBlockOthers(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "nacos:invalid", dynconf.NewRegCallback[int]("nacos_invalid"))
+	typemap.MustRegister[dynconf.Callback](ctx, "nacos:valid", dynconf.NewRegCallback[int]("nacos_valid"))
+
+	fake := newFakeNacos()
+	fake.publish("nacos", "invalid", `{"name": "nacos_invalid", "value": 0}`)
+	fake.publish("nacos", "valid", `{"name": "nacos_valid", "value": 0}`)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	newNacosDatas(t, server, `[
+		{"group": "nacos", "data_id": "invalid", "callbacks": ["nacos:invalid"]},
+		{"group": "nacos", "data_id": "valid", "callbacks": ["nacos:valid"]}
+	]`)
+	fake.publish("nacos", "invalid", `{"name": "nacos_invalid", "value": "invalid"}`)
+	time.Sleep(200 * time.Millisecond)
+	fake.publish("nacos", "valid", `{"name": "nacos_valid", "value": 1}`)
+	assert.Eventuallyf(t, func() bool {
+		value, err := typemap.Get[int](ctx, "nacos_valid")
+		return err == nil && value == 1
+	}, 3*time.Second, 10*time.Millisecond, "valid data applied although another data id is invalid")
+	value, err := typemap.Get[int](ctx, "nacos_invalid")
+	assert.Nilf(t, err, "invalid data not applied")
+	assert.Equalf(t, 0, value, "invalid data not applied")
+}
+
+func TestNacosSnapshot(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "nacos:snapshot:failed", dynconf.NewRegCallback[bool]("nacos_snapshot_failed"))
+	typemap.MustRegister[dynconf.Callback](ctx, "nacos:snapshot:not_found", dynconf.NewRegCallback[bool]("nacos_snapshot_not_found"))
+	dir := t.TempDir()
+	err := dynconf.SetSnapshotDir(dir, 0)
+	assert.Nilf(t, err, "set snapshot dir")
+	defer dynconf.SetSnapshotDir("", 0)
+	for _, name := range []string{"failed", "not_found"} {
+		sourceKey := "nacos:snapshot_" + name
+		b, _ := json.Marshal(dynconf.Snapshot{
+			SourceKey: sourceKey,
+			Data:      fmt.Sprintf(`{"name": "nacos_snapshot_%s", "value": true}`, name),
+			Callbacks: []string{"nacos:snapshot:" + name},
+			SavedAt:   time.Now(),
+		})
+		err = os.WriteFile(filepath.Join(dir, url.PathEscape(sourceKey)+".json"), b, 0600)
+		assert.Nilf(t, err, "write snapshot")
+	}
+
+	failing := newFakeNacos()
+	failing.setFailGets(true)
+	failingServer := httptest.NewServer(failing)
+	defer failingServer.Close()
+	n := newNacos(t, failingServer, "snapshot_failed", "nacos:snapshot:failed")
+	value, err := typemap.Get[bool](ctx, "nacos_snapshot_failed")
+	assert.Nilf(t, err, "snapshot applied")
+	assert.Truef(t, value, "snapshot of data id failed to query applied")
+	assert.Nilf(t, n.Ready(), "ready after snapshot applied")
+
+	notFoundServer := httptest.NewServer(newFakeNacos())
+	defer notFoundServer.Close()
+	n = newNacos(t, notFoundServer, "snapshot_not_found", "nacos:snapshot:not_found")
+	_, err = typemap.Get[bool](ctx, "nacos_snapshot_not_found")
+	assert.NotNilf(t, err, "snapshot of data id not found not applied")
+	assert.Nilf(t, n.Ready(), "data id not found considered delivered")
+}
@@ -0,0 +1,90 @@
+package dynconf
+
+import (
+	"context"
+	"strings"
+)
+
+// SetRaw set the raw-value mode of RegCallback with typemap instance name, in raw-value mode the data is the bare value
+// (e.g. `true` rather than `{"name": "degrade", "value": true}`), and the name comes from the registration
+func SetRaw(name string, raw bool) {
+	setDefaults(func(s *settings) {
+		if !raw {
+			delete(s.raws, name)
+			return
+		}
+		s.raws[name] = true
+	})
+}
+
+// IsRaw reports whether RegCallback with typemap instance name is in raw-value mode set by `SetRaw`, NOTE: the mode set
+// by the config executing the callback takes precedence
+func IsRaw(name string) bool {
+	return getDefaults().raws[name]
+}
+
+// NewRawRegCallback create a new RawRegCallback instance
+func NewRawRegCallback[T any](key string) Callback {
+	return &RawRegCallback[T]{
+		RegCallback: RegCallback[T]{
+			Name: key, // NOTE: important, will be used to validate equality of the Reg.Name!
+		},
+	}
+}
+
+// RawRegCallback is RegCallback always in raw-value mode regardless of `SetRaw`, i.e. data is the bare value
+type RawRegCallback[T any] struct {
+	RegCallback[T]
+}
+
+// Callback executes the callback with the default timeout
+func (r RawRegCallback[T]) Callback(sourceKey, data string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCallbackTimeout())
+	defer cancel()
+	return r.CallbackContext(ctx, sourceKey, data)
+}
+
+// CallbackContext implement ContextCallback
+func (r RawRegCallback[T]) CallbackContext(ctx context.Context, sourceKey, data string) error {
+	commit, err := r.Prepare(ctx, sourceKey, data)
+	if err != nil {
+		return err
+	}
+	return commit(ctx)
+}
+
+// Prepare implement TxCallback
+func (r RawRegCallback[T]) Prepare(ctx context.Context, sourceKey, data string) (func(ctx context.Context) error, error) {
+	return r.prepare(ctx, true, sourceKey, data)
+}
+
+// Save implement TxRestorer
+func (r RawRegCallback[T]) Save(ctx context.Context) (func(ctx context.Context) error, error) {
+	return r.save(ctx, true)
+}
+
+// ExportSchema implement SchemaExporter
+func (r RawRegCallback[T]) ExportSchema() (SchemaInfo, error) {
+	return r.exportSchema(true)
+}
+
+// rawIssues makes the paths of issues relative to the bare value in raw-value mode, e.g. `/value/rate` -> `/rate`
+func rawIssues(raw bool, issues []Issue) []Issue {
+	if !raw {
+		return issues
+	}
+	for i, issue := range issues {
+		if issue.Path == "/value" || strings.HasPrefix(issue.Path, "/value/") {
+			issues[i].Path = strings.TrimPrefix(issue.Path, "/value")
+		}
+	}
+	return issues
+}
+
+// Interface guard
+var (
+	_ TxRestorer      = (*RawRegCallback[any])(nil)
+	_ ContextCallback = (*RawRegCallback[any])(nil)
+	_ Valuer          = (*RawRegCallback[any])(nil)
+	_ SchemaExporter  = (*RawRegCallback[any])(nil)
+)
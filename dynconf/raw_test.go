@@ -0,0 +1,90 @@
+package dynconf_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawRegCallback(t *testing.T) {
+	ctx := context.Background()
+	cb := dynconf.NewRawRegCallback[RateLimit]("raw_rate_limit")
+	assert.Falsef(t, dynconf.IsRaw("raw_rate_limit"), "raw-value mode of the instance, not the name")
+	err := cb.Callback("raw", `{"rate": 100, "burst": 10}`)
+	assert.Nilf(t, err, "bare value")
+	value, _ := typemap.Get[RateLimit](ctx, "raw_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 100, Burst: 10}, value, "bare value")
+
+	err = cb.Callback("raw", `{"name": "raw_rate_limit", "value": {"rate": 1, "burst": 1}}`)
+	var verr *dynconf.ValidationError
+	assert.Truef(t, errors.As(err, &verr), "envelope is not allowed in raw-value mode")
+	paths := make([]string, 0, len(verr.Issues))
+	for _, issue := range verr.Issues {
+		paths = append(paths, issue.Path)
+	}
+	assert.Containsf(t, paths, "/name", "unknown field of the bare value")
+	assert.Containsf(t, paths, "/value", "unknown field of the bare value")
+	assert.Containsf(t, paths, "", "rate and burst are required at the root of the bare value")
+
+	dynconf.SetFormat("raw_rate_limit", dynconf.FormatYAML)
+	defer dynconf.SetFormat("raw_rate_limit", "")
+	err = cb.Callback("raw", "rate: fast\nburst: 2\n")
+	assert.Truef(t, errors.As(err, &verr), "invalid value")
+	assert.Equalf(t, "/rate", verr.Issues[0].Path, "issue path")
+	err = cb.Callback("raw", "rate: 2\nburst: 2\n")
+	assert.Nilf(t, err, "yaml bare value")
+	value, _ = typemap.Get[RateLimit](ctx, "raw_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 2, Burst: 2}, value, "yaml bare value")
+
+	info, err := cb.(dynconf.SchemaExporter).ExportSchema()
+	assert.Nilf(t, err, "export schema")
+	assert.Truef(t, info.Raw, "raw schema")
+
+	envelope := dynconf.NewRegCallback[RateLimit]("envelope_rate_limit")
+	err = envelope.Callback("raw", `{"rate": 1, "burst": 1}`)
+	assert.Truef(t, dynconf.IsValidationError(err), "envelope mode is the default")
+	err = envelope.Callback("raw", `{"name": "envelope_rate_limit", "value": {"rate": 1, "burst": 1}}`)
+	assert.Nilf(t, err, "envelope mode")
+
+	same := dynconf.NewRegCallback[RateLimit]("raw_rate_limit")
+	err = same.Callback("raw", `{"rate": 3, "burst": 3}`)
+	assert.Truef(t, dynconf.IsValidationError(err), "RegCallback of the same name is not raw")
+	err = same.Callback("raw", `{"name": "raw_rate_limit", "value": {"rate": 3, "burst": 3}}`)
+	assert.Nilf(t, err, "RegCallback of the same name is in envelope mode")
+	err = cb.Callback("raw", `{"rate": 4, "burst": 4}`)
+	assert.Nilf(t, err, "still raw")
+	revision := dynconf.History("raw_rate_limit")[0].Revision
+	err = cb.Callback("raw", `{"rate": 5, "burst": 5}`)
+	assert.Nilf(t, err, "still raw")
+	err = dynconf.Rollback("raw_rate_limit", revision)
+	assert.Nilf(t, err, "rollback with the raw callback")
+	value, _ = typemap.Get[RateLimit](ctx, "raw_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 4, Burst: 4}, value, "rolled back")
+}
+
+func TestRawRegCallbackString(t *testing.T) {
+	ctx := context.Background()
+	cb := dynconf.NewRawRegCallback[string]("raw_log_level")
+	err := cb.Callback("raw", "info")
+	assert.Nilf(t, err, "bare string")
+	value, _ := typemap.Get[string](ctx, "raw_log_level")
+	assert.Equalf(t, "info", value, "bare string")
+	err = cb.Callback("raw", `"debug"`)
+	assert.Nilf(t, err, "json string")
+	value, _ = typemap.Get[string](ctx, "raw_log_level")
+	assert.Equalf(t, "debug", value, "json string")
+	for _, data := range []string{"123", "true", "null"} {
+		err = cb.Callback("raw", data)
+		assert.Nilf(t, err, "json %s is a bare string", data)
+		value, _ = typemap.Get[string](ctx, "raw_log_level")
+		assert.Equalf(t, data, value, "json %s is a bare string", data)
+	}
+
+	number := dynconf.NewRawRegCallback[int]("raw_number")
+	err = number.Callback("raw", "info")
+	assert.Truef(t, dynconf.IsValidationError(err), "bare string is not quoted if not a string")
+}
@@ -0,0 +1,113 @@
+package dynconf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync"
+
+	"github.com/ccmonky/pkg/inithook"
+)
+
+// InstanceIDAttr is the InitHook attr used to set the instance id, e.g. `{"init_hook": {"attrs": {"instance_id": "web-1"}}}`
+const InstanceIDAttr = "instance_id"
+
+func init() {
+	err := inithook.RegisterAttrSetter(InstanceIDAttr, "dynconf", func(ctx context.Context, id string) error {
+		SetInstanceID(id)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+var instance = struct {
+	sync.RWMutex
+	id string
+}{}
+
+// SetInstanceID set the id of this instance used to select instances in rollout, empty means the hostname
+func SetInstanceID(id string) {
+	instance.Lock()
+	defer instance.Unlock()
+	instance.id = id
+}
+
+// InstanceID returns the id of this instance used to select instances in rollout, default is the hostname
+func InstanceID() string {
+	instance.RLock()
+	id := instance.id
+	instance.RUnlock()
+	if id != "" {
+		return id
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// Rollout selects the instances to apply the value of RegCallback, the rest instances skip the data and keep their
+// current values, e.g.
+//
+//	{"name": "degrade", "value": true, "rollout": {"percentage": 10, "instances": ["web-1"]}}
+//
+// applies to web-1 and about 10% of instances. Instances are selected by hash of the typemap instance name and the
+// instance id(see `InstanceID`), so that raising the percentage keeps the instances selected before. NOTE: to abort
+// a rollout, push the previous value without rollout; rollout is not supported in raw-value mode.
+type Rollout struct {
+	// Percentage is the percentage of instances to apply, in [0, 100]
+	Percentage float64 `json:"percentage,omitempty"`
+	// Instances are the ids of instances to apply explicitly
+	Instances []string `json:"instances,omitempty"`
+}
+
+// Validate returns error if percentage is out of range
+func (r Rollout) Validate() error {
+	if r.Percentage < 0 || r.Percentage > 100 {
+		return fmt.Errorf("percentage %v should be in [0, 100]", r.Percentage)
+	}
+	return nil
+}
+
+// Selected reports whether the instance with id is selected to apply the value of typemap instance name
+func (r Rollout) Selected(name, id string) bool {
+	for _, instance := range r.Instances {
+		if instance == id {
+			return true
+		}
+	}
+	return float64(rolloutBucket(name, id)) < r.Percentage*100
+}
+
+// rolloutBucket returns the bucket of instance in [0, 10000)
+func rolloutBucket(name, id string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(id))
+	return h.Sum32() % 10000
+}
+
+// extractRollout removes rollout from data since it is not part of the schema of value, and reports the issues
+func extractRollout(object map[string]any) (*Rollout, []Issue) {
+	v, ok := object["rollout"]
+	if !ok {
+		return nil, nil
+	}
+	delete(object, "rollout")
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, []Issue{{Path: "/rollout", Message: err.Error()}}
+	}
+	rollout := new(Rollout)
+	err = json.Unmarshal(data, rollout)
+	if err == nil {
+		err = rollout.Validate()
+	}
+	if err != nil {
+		return nil, []Issue{{Path: "/rollout", Message: err.Error()}}
+	}
+	return rollout, nil
+}
@@ -0,0 +1,52 @@
+package dynconf_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/pkg/inithook"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollout(t *testing.T) {
+	ctx := context.Background()
+	err := inithook.ExecuteAttrSetters(ctx, dynconf.InstanceIDAttr, "web-1")
+	assert.Nilf(t, err, "inithook")
+	defer dynconf.SetInstanceID("")
+	assert.Equalf(t, "web-1", dynconf.InstanceID(), "instance id")
+
+	cb := dynconf.NewRegCallback[RateLimit]("rollout_rate_limit")
+	err = cb.Callback("rollout", `{"name": "rollout_rate_limit", "value": {"rate": 1, "burst": 1}}`)
+	assert.Nilf(t, err, "full rollout")
+	err = cb.Callback("rollout", `{"name": "rollout_rate_limit", "value": {"rate": 2, "burst": 2}, "rollout": {"instances": ["web-2"]}}`)
+	assert.Nilf(t, err, "not selected")
+	value, _ := typemap.Get[RateLimit](ctx, "rollout_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 1, Burst: 1}, value, "skipped")
+	err = cb.Callback("rollout", `{"name": "rollout_rate_limit", "value": {"rate": 3, "burst": 3}, "rollout": {"instances": ["web-1"]}}`)
+	assert.Nilf(t, err, "selected explicitly")
+	value, _ = typemap.Get[RateLimit](ctx, "rollout_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 3, Burst: 3}, value, "applied")
+	err = cb.Callback("rollout", `{"name": "rollout_rate_limit", "value": {"rate": 4, "burst": 4}, "rollout": {"percentage": 100}}`)
+	assert.Nilf(t, err, "selected by percentage")
+	value, _ = typemap.Get[RateLimit](ctx, "rollout_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 4, Burst: 4}, value, "applied")
+	err = cb.Callback("rollout", `{"name": "rollout_rate_limit", "value": {"rate": 5, "burst": 5}, "rollout": {"percentage": 150}}`)
+	assert.Truef(t, dynconf.IsValidationError(err), "invalid percentage")
+	err = cb.Callback("rollout", `{"name": "rollout_rate_limit", "value": {"rate": 5, "burst": 5}, "rollout": {"percent": 10}}`)
+	assert.Truef(t, dynconf.IsValidationError(err), "unknown field of rollout")
+
+	canary := dynconf.Rollout{Percentage: 10}
+	wider := dynconf.Rollout{Percentage: 50}
+	var selected int
+	for i := 0; i < 10000; i++ {
+		id := fmt.Sprintf("web-%d", i)
+		if canary.Selected("degrade", id) {
+			selected++
+			assert.Truef(t, wider.Selected("degrade", id), "raising percentage keeps selected instances")
+		}
+	}
+	assert.InDeltaf(t, 1000, selected, 150, "about 10%% of instances")
+}
@@ -0,0 +1,78 @@
+package dynconf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ccmonky/typemap"
+)
+
+// SchemaInfo is the json schema enforced by a callback, exactly one of Schema and ValueSchema is set
+type SchemaInfo struct {
+	// Name is the typemap instance name
+	Name string `json:"name"`
+	// Type is the type of value
+	Type string `json:"type"`
+	// Schema is the schema of the whole data(i.e. `{"name": ..., "value": ...}`) generated from type
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// ValueSchema is the schema of `value` set by `SetSchema`, which overrides the generated schema
+	ValueSchema json.RawMessage `json:"value_schema,omitempty"`
+	// Raw is true if the RegCallback is in raw-value mode, i.e. data is the bare value which is validated against
+	// the `value` of Schema
+	Raw bool `json:"raw,omitempty"`
+}
+
+// SchemaExporter is implemented by callbacks which validate data with json schema, e.g. RegCallback
+type SchemaExporter interface {
+	ExportSchema() (SchemaInfo, error)
+}
+
+// ExportSchema implement SchemaExporter, returns the schema used to validate data
+func (r RegCallback[T]) ExportSchema() (SchemaInfo, error) {
+	return r.exportSchema(settingsOf(context.Background()).raws[r.Name])
+}
+
+func (r RegCallback[T]) exportSchema(raw bool) (SchemaInfo, error) {
+	info := SchemaInfo{
+		Name: r.Name,
+		Type: fmt.Sprintf("%T", *new(T)),
+		Raw:  raw,
+	}
+	if schema, ok := settingsOf(context.Background()).getOverride(r.Name); ok {
+		info.ValueSchema = schema
+		return info, nil
+	}
+	schema, err := generateSchema(&r)
+	if err != nil {
+		return info, fmt.Errorf("generate schema of %s failed: %v", r.Name, err)
+	}
+	info.Schema = schema
+	return info, nil
+}
+
+// Schemas returns the schemas of all registered callbacks which implement SchemaExporter, keyed by typemap instance name
+func Schemas(ctx context.Context) (map[string]SchemaInfo, error) {
+	callbacks, err := typemap.GetAll[Callback](ctx)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("get all callbacks failed: %v", err)
+	}
+	schemas := make(map[string]SchemaInfo)
+	for _, cb := range callbacks {
+		exporter, ok := cb.(SchemaExporter)
+		if !ok {
+			continue
+		}
+		info, err := exporter.ExportSchema()
+		if err != nil {
+			return nil, err
+		}
+		schemas[info.Name] = info
+	}
+	return schemas, nil
+}
+
+// Interface guard
+var (
+	_ SchemaExporter = (*RegCallback[any])(nil)
+)
@@ -0,0 +1,46 @@
+package dynconf_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+func TestSchemas(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "schemas:rate_limit", dynconf.NewRegCallback[RateLimit]("schemas_rate_limit"))
+	typemap.MustRegister[dynconf.Callback](ctx, "schemas:degrade", dynconf.NewRegCallback[Degrade]("schemas_degrade"))
+
+	schemas, err := dynconf.Schemas(ctx)
+	assert.Nilf(t, err, "schemas")
+	info, ok := schemas["schemas_rate_limit"]
+	assert.Truef(t, ok, "exported")
+	assert.Equalf(t, "dynconf_test.RateLimit", info.Type, "type")
+	assert.Nilf(t, info.ValueSchema, "no override")
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(info.Schema))
+	assert.Nilf(t, err, "valid schema")
+	result, _ := schema.Validate(gojsonschema.NewStringLoader(`{"name": "schemas_rate_limit", "value": {"rate": 1, "burst": 1}}`))
+	assert.Truef(t, result.Valid(), "valid data")
+	result, _ = schema.Validate(gojsonschema.NewStringLoader(`{"name": "schemas_rate_limit", "value": {"rate": 1}}`))
+	assert.Falsef(t, result.Valid(), "invalid data")
+
+	err = dynconf.SetSchema("schemas_degrade", []byte(`{"type": "object"}`))
+	assert.Nilf(t, err, "set schema")
+	defer dynconf.SetSchema("schemas_degrade", nil)
+	w, err := serveAdmin(http.MethodGet, dynconf.AdminAPIPrefix+"schemas?name=schemas_degrade", "")
+	assert.Nilf(t, err, "admin")
+	var overridden dynconf.SchemaInfo
+	err = json.Unmarshal(w.Body.Bytes(), &overridden)
+	assert.Nilf(t, err, "admin")
+	assert.Nilf(t, overridden.Schema, "overridden")
+	assert.JSONEqf(t, `{"type": "object"}`, string(overridden.ValueSchema), "override")
+
+	_, err = serveAdmin(http.MethodGet, dynconf.AdminAPIPrefix+"schemas?name=schemas_not_exists", "")
+	assert.NotNilf(t, err, "not found")
+}
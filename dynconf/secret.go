@@ -0,0 +1,315 @@
+package dynconf
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(LocalKeys{})
+}
+
+// SecretPrefix is the prefix of encrypted string values in data, i.e. `enc:[<key id>:]<base64 of nonce and ciphertext>`,
+// which are decrypted with AES-GCM by the key of the KeyProvider before validation, e.g.
+//
+//	{"name": "db", "value": {"user": "root", "password": "enc:k1:3q2+7w..."}}
+//
+// NOTE: the decrypted values are injected into typemap, and replaced by `RedactedValue` in logs, history, diffs and
+// the admin api, just like the fields tagged with `dynconf:"redact"`, see `Redact`
+//
+// NOTE: once a KeyProvider is set, every string value with `SecretPrefix` is decrypted, and fails validation if it is
+// not a valid secret, so an ordinary string starting with `enc:` should be escaped as `\enc:...`(i.e. `"\\enc:..."`
+// in json), the leading backslash is removed before validation
+const SecretPrefix = "enc:"
+
+// secretEscape escapes an ordinary string starting with `SecretPrefix`
+const secretEscape = `\` + SecretPrefix
+
+// KeyProvider provides the keys used to decrypt secrets in data
+type KeyProvider interface {
+	// Key returns the AES key(16, 24 or 32 bytes) with id, empty id means the default key
+	Key(id string) ([]byte, error)
+}
+
+// SetKeyProvider set the KeyProvider used to decrypt secrets, nil disables decryption, i.e. the values with
+// `SecretPrefix` are kept as they are
+func SetKeyProvider(p KeyProvider) {
+	setDefaults(func(s *settings) { s.keyProvider = p })
+}
+
+// GetKeyProvider returns the default KeyProvider used to decrypt secrets, nil if not set, NOTE: the KeyProvider of the
+// config executing the callback takes precedence
+func GetKeyProvider() KeyProvider {
+	return getDefaults().keyProvider
+}
+
+// Encrypt encrypts plaintext with AES-GCM by key, returns the secret in the form of `enc:[<key id>:]<base64>`
+func Encrypt(key []byte, id, plaintext string) (string, error) {
+	if strings.Contains(id, ":") {
+		return "", fmt.Errorf("invalid key id %q: should not contain `:`", id)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	secret := SecretPrefix
+	if id != "" {
+		secret += id + ":"
+	}
+	return secret + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts the secret in the form of `enc:[<key id>:]<base64>` with the key provided by p
+func Decrypt(p KeyProvider, secret string) (string, error) {
+	if !strings.HasPrefix(secret, SecretPrefix) {
+		return "", fmt.Errorf("secret should have prefix %s", SecretPrefix)
+	}
+	id, encoded := "", strings.TrimPrefix(secret, SecretPrefix)
+	if i := strings.LastIndex(encoded, ":"); i >= 0 {
+		id, encoded = encoded[:i], encoded[i+1:]
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("decode secret failed: %v", err)
+	}
+	key, err := p.Key(id)
+	if err != nil {
+		return "", fmt.Errorf("get key %q failed: %v", id, err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("decrypt secret with key %q failed: too short", id)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret with key %q failed: %v", id, err)
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decryptSecrets replaces the string values with `SecretPrefix` in json value by the decrypted values, records the
+// json pointers of decrypted values in secrets, and reports the failures as issues, NOTE: the issues never contain the secrets
+func decryptSecrets(p KeyProvider, value any, path string, secrets *[]string, issues *[]Issue) any {
+	switch v := value.(type) {
+	case string:
+		if strings.HasPrefix(v, secretEscape) {
+			return v[1:]
+		}
+		if !strings.HasPrefix(v, SecretPrefix) {
+			return v
+		}
+		plaintext, err := Decrypt(p, v)
+		if err != nil {
+			*issues = append(*issues, Issue{Path: path, Message: err.Error()})
+			return v
+		}
+		*secrets = append(*secrets, path)
+		return plaintext
+	case map[string]any:
+		for key, fv := range v {
+			v[key] = decryptSecrets(p, fv, path+"/"+escapePointer(key), secrets, issues)
+		}
+	case []any:
+		for i := range v {
+			v[i] = decryptSecrets(p, v[i], fmt.Sprintf("%s/%d", path, i), secrets, issues)
+		}
+	}
+	return value
+}
+
+// decrypted records the json pointers(relative to the value) of the secrets decrypted in the current value of
+// RegCallback with typemap instance name
+var decrypted = struct {
+	sync.RWMutex
+	paths map[string][]string
+}{
+	paths: make(map[string][]string),
+}
+
+func setDecrypted(name string, paths []string) {
+	decrypted.Lock()
+	defer decrypted.Unlock()
+	if len(paths) == 0 {
+		delete(decrypted.paths, name)
+		return
+	}
+	decrypted.paths[name] = paths
+}
+
+func getDecrypted(name string) []string {
+	decrypted.RLock()
+	defer decrypted.RUnlock()
+	return decrypted.paths[name]
+}
+
+// redactSecrets returns `Redact(v)` with the values at json pointers of secrets also replaced by `RedactedValue`
+func redactSecrets(v any, secrets []string) any {
+	redacted := Redact(v)
+	for _, path := range secrets {
+		if redactPointer(&redacted, path) {
+			continue
+		}
+		// NOTE: the values implementing json.Marshaler are kept by Redact, redact the json form of them
+		b, err := json.Marshal(redacted)
+		if err != nil {
+			return RedactedValue
+		}
+		redacted = nil
+		if err := json.Unmarshal(b, &redacted); err != nil || !redactPointer(&redacted, path) {
+			return RedactedValue
+		}
+	}
+	return redacted
+}
+
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// redactPointer replaces the value at json pointer path in the json value by `RedactedValue`, returns false if not found
+func redactPointer(value *any, path string) bool {
+	if path == "" {
+		*value = RedactedValue
+		return true
+	}
+	current := *value
+	tokens := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, token := range tokens {
+		token = pointerUnescaper.Replace(token)
+		last := i == len(tokens)-1
+		switch v := current.(type) {
+		case map[string]any:
+			fv, ok := v[token]
+			if !ok {
+				return false
+			}
+			if last {
+				v[token] = RedactedValue
+				return true
+			}
+			current = fv
+		case []any:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(v) {
+				return false
+			}
+			if last {
+				v[index] = RedactedValue
+				return true
+			}
+			current = v[index]
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// LocalKeys is a KeyProvider with keys configured locally, e.g.
+//
+//	{
+//	    "provider": "local",
+//	    "keys": {"": "{env.DYNCONF_KEY}"},
+//	    "files": {"k1": "/etc/caddy/dynconf.key"}
+//	}
+type LocalKeys struct {
+	// Keys maps key id to the base64 encoded key, placeholders like `{env.DYNCONF_KEY}` are replaced
+	Keys map[string]string `json:"keys,omitempty"`
+	// Files maps key id to the file containing the base64 encoded key
+	Files map[string]string `json:"files,omitempty"`
+
+	keys map[string][]byte
+}
+
+// ID caddy module id
+func (LocalKeys) ID() string {
+	return "config.ext.dynconf.keys.local"
+}
+
+// CaddyModule returns the Caddy module information.
+func (lk LocalKeys) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  caddy.ModuleID(lk.ID()),
+		New: func() caddy.Module { return new(LocalKeys) },
+	}
+}
+
+// Provision implement caddy.Provisioner, load and check the keys
+func (lk *LocalKeys) Provision(ctx caddy.Context) error {
+	lk.keys = make(map[string][]byte)
+	repl := caddy.NewReplacer()
+	for id, encoded := range lk.Keys {
+		err := lk.add(id, repl.ReplaceKnown(encoded, ""))
+		if err != nil {
+			return err
+		}
+	}
+	for id, file := range lk.Files {
+		if _, ok := lk.keys[id]; ok {
+			return fmt.Errorf("%s: key %q is duplicated in keys and files", lk.ID(), id)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("%s: read key %q failed: %v", lk.ID(), id, err)
+		}
+		err = lk.add(id, string(data))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lk *LocalKeys) add(id, encoded string) error {
+	if strings.Contains(id, ":") {
+		return fmt.Errorf("%s: invalid key id %q: should not contain `:`", lk.ID(), id)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return fmt.Errorf("%s: decode key %q failed: %v", lk.ID(), id, err)
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("%s: invalid key %q: %v", lk.ID(), id, err)
+	}
+	lk.keys[id] = key
+	return nil
+}
+
+// Key implement KeyProvider
+func (lk *LocalKeys) Key(id string) ([]byte, error) {
+	key, ok := lk.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%s: key %q not found", lk.ID(), id)
+	}
+	return key, nil
+}
+
+// Interface guard
+var (
+	_ caddy.Provisioner = (*LocalKeys)(nil)
+	_ KeyProvider       = (*LocalKeys)(nil)
+)
@@ -0,0 +1,142 @@
+package dynconf_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type Database struct {
+	User     string `json:"user"`
+	Password string `json:"password" dynconf:"redact"`
+}
+
+func TestSecrets(t *testing.T) {
+	ctx := context.Background()
+	key := []byte("0123456789abcdef0123456789abcdef")
+	other := []byte("fedcba9876543210")
+	t.Setenv("DYNCONF_TEST_KEY", base64.StdEncoding.EncodeToString(key))
+	file := filepath.Join(t.TempDir(), "k1.key")
+	err := os.WriteFile(file, []byte(base64.StdEncoding.EncodeToString(other)+"\n"), 0600)
+	assert.Nilf(t, err, "write key file")
+	keys := &dynconf.LocalKeys{
+		Keys:  map[string]string{"": "{env.DYNCONF_TEST_KEY}"},
+		Files: map[string]string{"k1": file},
+	}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	err = keys.Provision(caddyCtx)
+	assert.Nilf(t, err, "provision local keys")
+
+	secret, err := dynconf.Encrypt(key, "", "p@ss")
+	assert.Nilf(t, err, "encrypt")
+	assert.Truef(t, strings.HasPrefix(secret, dynconf.SecretPrefix), "prefix")
+	plaintext, err := dynconf.Decrypt(keys, secret)
+	assert.Nilf(t, err, "decrypt")
+	assert.Equalf(t, "p@ss", plaintext, "decrypt")
+	secret1, err := dynconf.Encrypt(other, "k1", "p@ss1")
+	assert.Nilf(t, err, "encrypt with key id")
+	assert.Truef(t, strings.HasPrefix(secret1, dynconf.SecretPrefix+"k1:"), "key id")
+
+	cb := dynconf.NewRegCallback[Database]("secrets_database")
+	err = cb.Callback("secrets", `{"name": "secrets_database", "value": {"user": "root", "password": "`+secret+`"}}`)
+	assert.Nilf(t, err, "no key provider")
+	value, _ := typemap.Get[Database](ctx, "secrets_database")
+	assert.Equalf(t, secret, value.Password, "secrets are kept without key provider")
+
+	dynconf.SetKeyProvider(keys)
+	defer dynconf.SetKeyProvider(nil)
+	err = cb.Callback("secrets", `{"name": "secrets_database", "value": {"user": "root", "password": "`+secret1+`"}}`)
+	assert.Nilf(t, err, "decrypt with key k1")
+	value, _ = typemap.Get[Database](ctx, "secrets_database")
+	assert.Equalf(t, Database{User: "root", Password: "p@ss1"}, value, "decrypted")
+
+	wrong, _ := dynconf.Encrypt(other, "", "p@ss")
+	err = cb.Callback("secrets", `{"name": "secrets_database", "value": {"user": "`+secret+`", "password": "`+wrong+`"}}`)
+	var verr *dynconf.ValidationError
+	assert.Truef(t, errors.As(err, &verr), "wrong key")
+	assert.Equalf(t, 1, len(verr.Issues), "only the password")
+	assert.Equalf(t, "/value/password", verr.Issues[0].Path, "issue path")
+	assert.NotContainsf(t, err.Error(), "p@ss", "no plaintext in error")
+	_, err = dynconf.Decrypt(keys, dynconf.SecretPrefix+"k2:"+strings.TrimPrefix(secret, dynconf.SecretPrefix))
+	assert.NotNilf(t, err, "key not found")
+
+	raw := dynconf.NewRawRegCallback[string]("secrets_token")
+	err = raw.Callback("secrets", `"`+secret+`"`)
+	assert.Nilf(t, err, "raw secret")
+	token, _ := typemap.Get[string](ctx, "secrets_token")
+	assert.Equalf(t, "p@ss", token, "raw secret")
+
+	invalid := &dynconf.LocalKeys{Keys: map[string]string{"k:1": base64.StdEncoding.EncodeToString(key)}}
+	assert.NotNilf(t, invalid.Provision(caddyCtx), "invalid key id")
+	invalid = &dynconf.LocalKeys{Keys: map[string]string{"": base64.StdEncoding.EncodeToString([]byte("short"))}}
+	assert.NotNilf(t, invalid.Provision(caddyCtx), "invalid key size")
+}
+
+// Endpoint has no field tagged with `dynconf:"redact"`
+type Endpoint struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+func TestSecretsRedacted(t *testing.T) {
+	ctx := context.Background()
+	key := []byte("0123456789abcdef")
+	keys := &dynconf.LocalKeys{Keys: map[string]string{"": base64.StdEncoding.EncodeToString(key)}}
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	assert.Nilf(t, keys.Provision(caddyCtx), "provision local keys")
+	dynconf.SetKeyProvider(keys)
+	defer dynconf.SetKeyProvider(nil)
+	core, logs := observer.New(zap.InfoLevel)
+	dynconf.SetLogger(zap.New(core))
+	defer dynconf.SetLogger(nil)
+
+	secret1, _ := dynconf.Encrypt(key, "", "plaintext-1")
+	secret2, _ := dynconf.Encrypt(key, "", "plaintext-2")
+	cb := dynconf.NewRegCallback[Endpoint]("secrets_endpoint")
+	err := cb.Callback("secrets", `{"name": "secrets_endpoint", "value": {"url": "http://a", "token": "`+secret1+`"}}`)
+	assert.Nilf(t, err, "first time")
+	err = cb.Callback("secrets", `{"name": "secrets_endpoint", "value": {"url": "http://b", "token": "`+secret2+`"}}`)
+	assert.Nilf(t, err, "change")
+	value, _ := typemap.Get[Endpoint](ctx, "secrets_endpoint")
+	assert.Equalf(t, Endpoint{URL: "http://b", Token: "plaintext-2"}, value, "decrypted")
+	raw := dynconf.NewRawRegCallback[string]("secrets_raw_token")
+	err = raw.Callback("secrets", `"`+secret1+`"`)
+	assert.Nilf(t, err, "raw secret")
+
+	entries := logs.All()
+	assert.Equalf(t, map[string]any{"url": "http://a", "token": dynconf.RedactedValue}, entries[0].ContextMap()["new"], "new redacted")
+	assert.Equalf(t, []dynconf.Operation{
+		{Op: "replace", Path: "/url", Value: "http://b", Old: "http://a"},
+	}, entries[1].ContextMap()["diff"], "diff of redacted values")
+	assert.Equalf(t, dynconf.RedactedValue, entries[2].ContextMap()["new"], "raw value redacted")
+	for _, entry := range entries {
+		for _, v := range entry.ContextMap() {
+			assert.NotContainsf(t, fmt.Sprint(v), "plaintext", "secret leaked in logs")
+		}
+	}
+	for _, name := range []string{"secrets_endpoint", "secrets_raw_token"} {
+		for _, rec := range dynconf.History(name) {
+			assert.NotContainsf(t, fmt.Sprint(rec), "plaintext", "secret leaked in history")
+		}
+	}
+
+	err = cb.Callback("secrets", `{"name": "secrets_endpoint", "value": {"url": "http://c", "token": "\\`+secret1+`"}}`)
+	assert.Nilf(t, err, "escaped")
+	value, _ = typemap.Get[Endpoint](ctx, "secrets_endpoint")
+	assert.Equalf(t, Endpoint{URL: "http://c", Token: secret1}, value, "escaped string is not decrypted")
+	assert.Equalf(t, map[string]any{"url": "http://c", "token": secret1}, dynconf.History("secrets_endpoint")[0].Value, "not a secret")
+}
@@ -0,0 +1,179 @@
+package dynconf
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// settings are the settings of executing callbacks, each config builds its own settings from `Callbacks` and passes
+// them to the callbacks executed by its listeners through the context(see `withSettings`), so that provisioning a new
+// config never changes the settings used by the running one.
+//
+// NOTE: settings are never modified once built, the setters of the defaults(e.g. `SetStrictness`) replace the defaults
+// with a modified copy
+type settings struct {
+	logger         *zap.Logger
+	historySize    int
+	timeout        time.Duration
+	timeouts       map[string]time.Duration // callback key -> timeout
+	strictness     Strictness
+	strictnesses   map[string]Strictness // typemap instance name -> strictness
+	formats        map[string]Format     // typemap instance name -> format
+	raws           map[string]bool       // typemap instance name -> raw-value mode
+	overrides      map[string]override   // typemap instance name -> schema
+	throttles      map[string]Throttle   // callback key -> throttle
+	snapshotDir    string
+	snapshotMaxAge time.Duration
+	keyProvider    KeyProvider
+}
+
+func newSettings() *settings {
+	return &settings{
+		historySize:  DefaultHistorySize,
+		timeout:      DefaultCallbackTimeout,
+		timeouts:     make(map[string]time.Duration),
+		strictness:   Strict,
+		strictnesses: make(map[string]Strictness),
+		formats:      make(map[string]Format),
+		raws:         make(map[string]bool),
+		overrides:    make(map[string]override),
+		throttles:    make(map[string]Throttle),
+	}
+}
+
+func (s *settings) clone() *settings {
+	c := *s
+	c.timeouts = copyMap(s.timeouts)
+	c.strictnesses = copyMap(s.strictnesses)
+	c.formats = copyMap(s.formats)
+	c.raws = copyMap(s.raws)
+	c.overrides = copyMap(s.overrides)
+	c.throttles = copyMap(s.throttles)
+	return &c
+}
+
+// getLogger returns the logger, default is the caddy default logger named `dynconf`
+func (s *settings) getLogger() *zap.Logger {
+	if s.logger == nil {
+		return caddy.Log().Named("dynconf")
+	}
+	return s.logger
+}
+
+// callbackTimeout returns the timeout of the callback registered with key, see `CallbackTimeout`
+func (s *settings) callbackTimeout(ctx context.Context, key string) time.Duration {
+	if timeout, ok := s.timeouts[key]; ok {
+		return timeout
+	}
+	if timeout, ok := ctx.Value(callbackTimeoutKey{}).(time.Duration); ok {
+		return timeout
+	}
+	return s.timeout
+}
+
+func (s *settings) getStrictness(name string) Strictness {
+	if strictness, ok := s.strictnesses[name]; ok {
+		return strictness
+	}
+	return s.strictness
+}
+
+func (s *settings) getFormat(name string) Format {
+	if f, ok := s.formats[name]; ok {
+		return f
+	}
+	return FormatJSON
+}
+
+func (s *settings) getThrottle(key string) (Throttle, bool) {
+	t, ok := s.throttles[key]
+	return t, ok
+}
+
+// defaults are the settings used by callbacks executed without the settings of any config, e.g. by code outside caddy
+var defaults = struct {
+	sync.RWMutex
+	s *settings
+}{
+	s: newSettings(),
+}
+
+func getDefaults() *settings {
+	defaults.RLock()
+	defer defaults.RUnlock()
+	return defaults.s
+}
+
+// setDefaults replaces the defaults with a copy modified by set
+func setDefaults(set func(s *settings)) {
+	defaults.Lock()
+	defer defaults.Unlock()
+	s := defaults.s.clone()
+	set(s)
+	defaults.s = s
+}
+
+// active is the settings of configs provisioned and not cleaned up yet in provisioning order, the latest one is used
+// by callbacks executed outside listeners, e.g. values pushed by admin api, see `settingsOf`
+var active = struct {
+	sync.RWMutex
+	list []*settings
+}{}
+
+func activate(s *settings) {
+	active.Lock()
+	defer active.Unlock()
+	active.list = append(active.list, s)
+}
+
+func deactivate(s *settings) {
+	active.Lock()
+	defer active.Unlock()
+	for i, a := range active.list {
+		if a == s {
+			active.list = append(active.list[:i], active.list[i+1:]...)
+			return
+		}
+	}
+}
+
+type settingsKey struct{}
+
+// withSettings returns ctx carrying settings, nil settings is ignored
+func withSettings(ctx context.Context, s *settings) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, settingsKey{}, s)
+}
+
+// settingsFrom returns the settings carried by ctx, nil if not carried
+func settingsFrom(ctx context.Context) *settings {
+	s, _ := ctx.Value(settingsKey{}).(*settings)
+	return s
+}
+
+// settingsOf returns the settings carried by ctx, or the settings of the latest active config, or the defaults
+func settingsOf(ctx context.Context) *settings {
+	if s := settingsFrom(ctx); s != nil {
+		return s
+	}
+	active.RLock()
+	defer active.RUnlock()
+	if n := len(active.list); n > 0 {
+		return active.list[n-1]
+	}
+	return getDefaults()
+}
+
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	result := make(map[K]V, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,229 @@
+package dynconf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ccmonky/typemap"
+	"go.uber.org/zap"
+)
+
+// Snapshot is the last data of a sourceKey applied successfully, persisted in the snapshot directory, and applied by
+// the listener owning the sourceKey at startup(after defaults) in case the config center is unreachable
+type Snapshot struct {
+	SourceKey string `json:"source_key"`
+	// Data is the json data(i.e. converted from Format), secrets are kept encrypted, see `SecretPrefix`
+	Data string `json:"data"`
+	// Format is the format Data was converted from, see `WithSourceFormat`
+	Format    Format    `json:"format,omitempty"`
+	Callbacks []string  `json:"callbacks"`
+	SavedAt   time.Time `json:"saved_at"`
+}
+
+// Freshness indicates where the current data of a sourceKey comes from
+type Freshness struct {
+	SourceKey string `json:"source_key"`
+	// Source is `snapshot` if the data was applied from snapshot and not updated by listener yet, otherwise `live`
+	Source string `json:"source"`
+	// UpdatedAt is the time when the snapshot was saved, or the time when the live data was applied
+	UpdatedAt time.Time `json:"updated_at"`
+	// Stale is true if the data is from snapshot which is older than the max age, see `SetSnapshotDir`
+	Stale bool `json:"stale"`
+}
+
+// sources of data
+const (
+	SourceSnapshot = "snapshot"
+	SourceLive     = "live"
+)
+
+var snapshots = struct {
+	sync.RWMutex
+	sources map[string]Freshness
+}{
+	sources: make(map[string]Freshness),
+}
+
+// SetSnapshotDir set the directory to persist snapshots, empty disables snapshots, the data from snapshots older than
+// maxAge is reported as stale, maxAge <= 0 means no limit
+func SetSnapshotDir(dir string, maxAge time.Duration) error {
+	err := makeSnapshotDir(dir)
+	if err != nil {
+		return err
+	}
+	setDefaults(func(s *settings) { s.snapshotDir, s.snapshotMaxAge = dir, maxAge })
+	return nil
+}
+
+func makeSnapshotDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return fmt.Errorf("create snapshot dir %s failed: %v", dir, err)
+	}
+	return nil
+}
+
+// markApplied marks sourceKey as live and persists the data applied successfully by callbacks as snapshot in the
+// snapshot directory of the settings of ctx
+func markApplied(ctx context.Context, sourceKey, data string, callbacks []typemap.Ref[Callback]) {
+	now := time.Now()
+	dynconfMetrics.lastSuccess.WithLabelValues(sourceKey).Set(float64(now.UnixNano()) / 1e9)
+	snapshots.Lock()
+	snapshots.sources[sourceKey] = Freshness{SourceKey: sourceKey, Source: SourceLive, UpdatedAt: now}
+	snapshots.Unlock()
+	s := settingsOf(ctx)
+	dir := s.snapshotDir
+	if dir == "" {
+		return
+	}
+	snapshot := Snapshot{SourceKey: sourceKey, Data: data, Format: sourceFormat(ctx), SavedAt: now}
+	for i := range callbacks {
+		snapshot.Callbacks = append(snapshot.Callbacks, callbacks[i].Name)
+	}
+	err := writeSnapshot(dir, snapshot)
+	if err != nil {
+		s.getLogger().Warn("save snapshot failed", zap.String("source_key", sourceKey), zap.Error(err))
+	}
+}
+
+func writeSnapshot(dir string, snapshot Snapshot) error {
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	// NOTE: write to a temp file then rename, so that the snapshot is never half written
+	tmp, err := os.CreateTemp(dir, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, url.PathEscape(snapshot.SourceKey)+".json"))
+}
+
+// LoadSnapshots returns all snapshots in the snapshot directory sorted by sourceKey, invalid snapshots are skipped
+func LoadSnapshots() ([]Snapshot, error) {
+	return loadSnapshots(settingsOf(context.Background()))
+}
+
+func loadSnapshots(s *settings) ([]Snapshot, error) {
+	dir := s.snapshotDir
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot dir %s failed: %v", dir, err)
+	}
+	var result []Snapshot
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		var snapshot Snapshot
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err == nil {
+			err = json.Unmarshal(b, &snapshot)
+		}
+		if err != nil {
+			s.getLogger().Warn("invalid snapshot skipped", zap.String("file", entry.Name()), zap.Error(err))
+			continue
+		}
+		result = append(result, snapshot)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].SourceKey < result[j].SourceKey
+	})
+	return result, nil
+}
+
+// ApplySnapshots executes callbacks with the snapshots of sourceKeys, used by listeners when failed to fetch the initial
+// data, so that the snapshots of sourceKeys not owned by any listener are never applied. Failures are logged and skipped,
+// since the listeners will deliver the latest data later. Returns the sourceKeys applied
+func ApplySnapshots(ctx context.Context, sourceKeys ...string) []string {
+	return applySnapshots(ctx, func(sourceKey string) bool {
+		for _, key := range sourceKeys {
+			if key == sourceKey {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// ApplySnapshotsPrefix is like ApplySnapshots, but applies the snapshots of all sourceKeys with prefix, e.g. etcd prefix
+func ApplySnapshotsPrefix(ctx context.Context, prefix string) []string {
+	return applySnapshots(ctx, func(sourceKey string) bool {
+		return strings.HasPrefix(sourceKey, prefix)
+	})
+}
+
+func applySnapshots(ctx context.Context, match func(sourceKey string) bool) []string {
+	s := settingsOf(ctx)
+	result, err := loadSnapshots(s)
+	if err != nil {
+		s.getLogger().Error("load snapshots failed", zap.Error(err))
+		return nil
+	}
+	var applied []string
+	for _, snapshot := range result {
+		if !match(snapshot.SourceKey) {
+			continue
+		}
+		callbacks := make([]typemap.Ref[Callback], len(snapshot.Callbacks))
+		for i, name := range snapshot.Callbacks {
+			callbacks[i].Name = name
+		}
+		err := execute(WithSourceFormat(ctx, snapshot.Format), callbacks, snapshot.SourceKey, snapshot.Data, nil)
+		if err != nil {
+			s.getLogger().Error("apply snapshot failed",
+				zap.String("source_key", snapshot.SourceKey),
+				zap.Time("saved_at", snapshot.SavedAt),
+				zap.Error(err))
+			continue
+		}
+		snapshots.Lock()
+		// NOTE: on config reload, the snapshot is the same as the live data which is still fresh
+		if f, ok := snapshots.sources[snapshot.SourceKey]; !ok || f.Source != SourceLive {
+			snapshots.sources[snapshot.SourceKey] = Freshness{SourceKey: snapshot.SourceKey, Source: SourceSnapshot, UpdatedAt: snapshot.SavedAt}
+		}
+		snapshots.Unlock()
+		applied = append(applied, snapshot.SourceKey)
+		s.getLogger().Info("snapshot applied", zap.String("source_key", snapshot.SourceKey), zap.Time("saved_at", snapshot.SavedAt))
+	}
+	return applied
+}
+
+// Freshnesses returns the freshness of data of all sourceKeys applied, keyed by sourceKey
+func Freshnesses() map[string]Freshness {
+	return freshnesses(settingsOf(context.Background()).snapshotMaxAge)
+}
+
+// freshnesses is like Freshnesses, but the data is stale if from snapshot older than maxAge
+func freshnesses(maxAge time.Duration) map[string]Freshness {
+	snapshots.RLock()
+	defer snapshots.RUnlock()
+	result := make(map[string]Freshness, len(snapshots.sources))
+	for sourceKey, f := range snapshots.sources {
+		f.Stale = f.Source == SourceSnapshot && maxAge > 0 && time.Since(f.UpdatedAt) > maxAge
+		result[sourceKey] = f
+	}
+	return result
+}
@@ -0,0 +1,80 @@
+package dynconf_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshots(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	err := dynconf.SetSnapshotDir(dir, time.Minute)
+	assert.Nilf(t, err, "set snapshot dir")
+	defer dynconf.SetSnapshotDir("", 0)
+	typemap.MustRegister[dynconf.Callback](ctx, "snapshot:rate_limit", dynconf.NewRegCallback[RateLimit]("snapshot_rate_limit"))
+	callbacks := []typemap.Ref[dynconf.Callback]{{Name: "snapshot:rate_limit"}}
+
+	err = dynconf.Execute(ctx, callbacks, "snapshot/live", `{"name": "snapshot_rate_limit", "value": {"rate": 1, "burst": 1}}`)
+	assert.Nilf(t, err, "execute")
+	_, err = os.Stat(filepath.Join(dir, "snapshot%2Flive.json"))
+	assert.Nilf(t, err, "snapshot saved")
+	err = dynconf.Execute(ctx, callbacks, "snapshot/invalid", `{"name": "snapshot_rate_limit", "value": {"rate": "fast"}}`)
+	assert.NotNilf(t, err, "invalid")
+	snapshots, err := dynconf.LoadSnapshots()
+	assert.Nilf(t, err, "load snapshots")
+	assert.Equalf(t, 1, len(snapshots), "only applied data is saved")
+	assert.Equalf(t, []string{"snapshot:rate_limit"}, snapshots[0].Callbacks, "callbacks")
+	assert.Equalf(t, dynconf.SourceLive, dynconf.Freshnesses()["snapshot/live"].Source, "live")
+
+	old := dynconf.Snapshot{
+		SourceKey: "snapshot/old",
+		Data:      `{"name": "snapshot_rate_limit", "value": {"rate": 2, "burst": 2}}`,
+		Callbacks: []string{"snapshot:rate_limit"},
+		SavedAt:   time.Now().Add(-time.Hour),
+	}
+	b, _ := json.Marshal(old)
+	err = os.WriteFile(filepath.Join(dir, "snapshot%2Fold.json"), b, 0600)
+	assert.Nilf(t, err, "write snapshot")
+	orphan := old
+	orphan.SourceKey = "snapshot/orphan"
+	orphan.Data = `{"name": "snapshot_rate_limit", "value": {"rate": 9, "burst": 9}}`
+	b, _ = json.Marshal(orphan)
+	err = os.WriteFile(filepath.Join(dir, "snapshot%2Forphan.json"), b, 0600)
+	assert.Nilf(t, err, "write orphan snapshot")
+	err = os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{"), 0600)
+	assert.Nilf(t, err, "write corrupt snapshot")
+
+	applied := dynconf.ApplySnapshots(ctx, "snapshot/old", "snapshot/missing")
+	assert.Equalf(t, []string{"snapshot/old"}, applied, "only snapshots of owned source keys applied")
+	value, _ := typemap.Get[RateLimit](ctx, "snapshot_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 2, Burst: 2}, value, "snapshot applied")
+	_, ok := dynconf.Freshnesses()["snapshot/orphan"]
+	assert.Falsef(t, ok, "orphan snapshot not applied")
+	freshness := dynconf.Freshnesses()["snapshot/old"]
+	assert.Equalf(t, dynconf.SourceSnapshot, freshness.Source, "from snapshot")
+	assert.Truef(t, freshness.Stale, "stale")
+	assert.Equalf(t, dynconf.SourceLive, dynconf.Freshnesses()["snapshot/live"].Source, "still live")
+
+	err = dynconf.ExecuteBatch(ctx, []dynconf.Change{{
+		SourceKey: "snapshot/old",
+		Data:      "name: snapshot_rate_limit\nvalue:\n  rate: 3\n  burst: 3\n",
+		Format:    dynconf.FormatYAML,
+		Callbacks: callbacks,
+	}})
+	assert.Nilf(t, err, "live data")
+	assert.Equalf(t, dynconf.SourceLive, dynconf.Freshnesses()["snapshot/old"].Source, "live")
+	assert.Falsef(t, dynconf.Freshnesses()["snapshot/old"].Stale, "fresh")
+	snapshots, _ = dynconf.LoadSnapshots()
+	assert.Equalf(t, 3, len(snapshots), "snapshots")
+	assert.JSONEqf(t, `{"name": "snapshot_rate_limit", "value": {"rate": 3, "burst": 3}}`, snapshots[1].Data, "converted json is saved")
+	applied = dynconf.ApplySnapshotsPrefix(ctx, "snapshot/o")
+	assert.Equalf(t, []string{"snapshot/old", "snapshot/orphan"}, applied, "snapshots with prefix applied")
+}
@@ -0,0 +1,259 @@
+package dynconf
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Strictness is the policy of handling unknown fields and duplicate keys in data of RegCallback
+type Strictness string
+
+const (
+	// Strict rejects data with unknown fields or duplicate keys, it is the default policy
+	Strict Strictness = "strict"
+	// WarnOnUnknown ignores unknown fields and duplicate keys(the last one wins) with a warning log
+	WarnOnUnknown Strictness = "warn"
+	// Lenient ignores unknown fields and duplicate keys(the last one wins) silently
+	Lenient Strictness = "lenient"
+)
+
+// Validate returns error if s is not a valid policy, empty means the default policy
+func (s Strictness) Validate() error {
+	switch s {
+	case "", Strict, WarnOnUnknown, Lenient:
+		return nil
+	}
+	return fmt.Errorf("invalid strictness %q, available values are: [%s, %s, %s]", s, Strict, WarnOnUnknown, Lenient)
+}
+
+// SetDefaultStrictness set the default strictness of RegCallbacks, empty means Strict
+func SetDefaultStrictness(s Strictness) {
+	if s == "" {
+		s = Strict
+	}
+	setDefaults(func(d *settings) { d.strictness = s })
+}
+
+// SetStrictness set the strictness of RegCallback with typemap instance name, empty removes it
+func SetStrictness(name string, s Strictness) {
+	setDefaults(func(d *settings) {
+		if s == "" {
+			delete(d.strictnesses, name)
+			return
+		}
+		d.strictnesses[name] = s
+	})
+}
+
+// GetStrictness returns the default strictness of RegCallback with typemap instance name, NOTE: the strictness set by
+// the config executing the callback takes precedence
+func GetStrictness(name string) Strictness {
+	return getDefaults().getStrictness(name)
+}
+
+// Issue is a problem found in data, located by json pointer(RFC 6901)
+type Issue struct {
+	// Path is a json pointer, empty means the whole data
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string {
+	if i.Path == "" {
+		return i.Message
+	}
+	return i.Path + ": " + i.Message
+}
+
+// ValidationError reports all issues found in data of RegCallback, including syntax errors, duplicate keys,
+// unknown fields and json schema violations
+type ValidationError struct {
+	Name   string  `json:"name"`
+	Issues []Issue `json:"issues"`
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	issues := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		issues[i] = issue.String()
+	}
+	return fmt.Sprintf("invalid data of %s: %s", e.Name, strings.Join(issues, "; "))
+}
+
+// IsValidationError reports whether err is a ValidationError
+func IsValidationError(err error) bool {
+	var e *ValidationError
+	return errors.As(err, &e)
+}
+
+// decodeJSON decodes data into json value(numbers as json.Number), duplicate keys are reported as issues,
+// and the last one wins as encoding/json does
+func decodeJSON(data []byte) (any, []Issue, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var duplicates []Issue
+	value, err := decodeJSONValue(decoder, "", &duplicates)
+	if err == nil {
+		if _, err = decoder.Token(); err == io.EOF {
+			return value, duplicates, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("invalid character after top-level value")
+		}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("unexpected end of JSON input")
+	}
+	line, column := position(data, decoder.InputOffset())
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, column = position(data, syntaxErr.Offset)
+	}
+	return nil, nil, fmt.Errorf("line %d, column %d: %v", line, column, err)
+}
+
+func decodeJSONValue(decoder *json.Decoder, path string, duplicates *[]Issue) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return token, nil
+	}
+	switch delim {
+	case '{':
+		object := make(map[string]any)
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := token.(string)
+			keyPath := path + "/" + escapePointer(key)
+			if _, ok := object[key]; ok {
+				*duplicates = append(*duplicates, Issue{Path: keyPath, Message: "duplicate key"})
+			}
+			object[key], err = decodeJSONValue(decoder, keyPath, duplicates)
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err = decoder.Token()
+		return object, err
+	default: // '['
+		array := make([]any, 0)
+		for decoder.More() {
+			value, err := decodeJSONValue(decoder, fmt.Sprintf("%s/%d", path, len(array)), duplicates)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = decoder.Token()
+		return array, err
+	}
+}
+
+// position returns the 1-based line and column of offset in data
+func position(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// pruneUnknown removes the fields of json value which are not decoded into type t, and reports them as issues
+func pruneUnknown(t reflect.Type, value any, path string, unknowns *[]Issue) any {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(jsonUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return value
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := jsonFields(t)
+			for key, fv := range v {
+				keyPath := path + "/" + escapePointer(key)
+				ft, ok := fields[key]
+				if !ok {
+					*unknowns = append(*unknowns, Issue{Path: keyPath, Message: "unknown field"})
+					delete(v, key)
+					continue
+				}
+				v[key] = pruneUnknown(ft, fv, keyPath, unknowns)
+			}
+		case reflect.Map:
+			for key, fv := range v {
+				v[key] = pruneUnknown(t.Elem(), fv, path+"/"+escapePointer(key), unknowns)
+			}
+		}
+	case []any:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i := range v {
+				v[i] = pruneUnknown(t.Elem(), v[i], fmt.Sprintf("%s/%d", path, i), unknowns)
+			}
+		}
+	}
+	return value
+}
+
+// jsonFields returns the json names of fields of struct type t, with the fields of embedded structs
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonTag := field.Tag.Get("json")
+		if jsonTag == "-" {
+			continue
+		}
+		name := strings.Split(jsonTag, ",")[0]
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for k, v := range jsonFields(ft) {
+					if _, ok := fields[k]; !ok {
+						fields[k] = v
+					}
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func sortIssues(issues []Issue) {
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Path < issues[j].Path
+	})
+}
@@ -0,0 +1,52 @@
+package dynconf_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrictness(t *testing.T) {
+	ctx := context.Background()
+	cb := dynconf.NewRegCallback[RateLimit]("strict_rate_limit")
+
+	// 1. strict: all issues reported at once
+	err := cb.Callback("strict", `{
+		"name": "strict_rate_limit",
+		"value": {"rate": 1, "rate": 2, "burst": "1", "unknown": true},
+		"extra": 1
+	}`)
+	var verr *dynconf.ValidationError
+	assert.Truef(t, errors.As(err, &verr), "validation error")
+	assert.Equalf(t, []dynconf.Issue{
+		{Path: "/value/rate", Message: "duplicate key"},
+		{Path: "/extra", Message: "unknown field"},
+		{Path: "/value/unknown", Message: "unknown field"},
+		{Path: "/value/burst", Message: "Invalid type. Expected: integer, given: string"},
+	}, verr.Issues, "issues")
+
+	// 2. syntax error with position
+	err = cb.Callback("strict", "{\n\t\"name\": \"strict_rate_limit\",\n\t\"value\": {\"rate\": 1,}\n}")
+	assert.Truef(t, dynconf.IsValidationError(err), "validation error")
+	assert.Containsf(t, err.Error(), "line 3, column 22", "position")
+
+	// 3. warn: unknown fields and duplicate keys ignored
+	dynconf.SetStrictness("strict_rate_limit", dynconf.WarnOnUnknown)
+	defer dynconf.SetStrictness("strict_rate_limit", "")
+	err = cb.Callback("strict", `{"name": "strict_rate_limit", "value": {"rate": 1, "rate": 2, "burst": 3, "unknown": true}, "extra": 1}`)
+	assert.Nilf(t, err, "warn")
+	value, _ := typemap.Get[RateLimit](ctx, "strict_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 2, Burst: 3}, value, "last one wins")
+
+	// 4. lenient: schema violations are still reported
+	dynconf.SetStrictness("strict_rate_limit", dynconf.Lenient)
+	err = cb.Callback("strict", `{"name": "strict_rate_limit", "value": {"rate": 1, "unknown": true}}`)
+	assert.Truef(t, errors.As(err, &verr), "validation error")
+	assert.Equalf(t, []dynconf.Issue{{Path: "/value", Message: "burst is required"}}, verr.Issues, "missing field")
+
+	assert.NotNilf(t, dynconf.Strictness("bad").Validate(), "invalid strictness")
+}
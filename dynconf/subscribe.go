@@ -0,0 +1,123 @@
+package dynconf
+
+import (
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Event is sent to subscribers after RegCallback commits a new value of T into typemap
+type Event[T any] struct {
+	// Name is the typemap instance name
+	Name      string
+	SourceKey string
+	Revision  int64
+	// OldFound is false if the value is registered first time
+	OldFound bool
+	Old      T
+	New      T
+}
+
+// Diff returns the field-level diff from old value to new value, note that the values are not redacted
+func (e Event[T]) Diff() ([]Operation, error) {
+	if !e.OldFound {
+		return Diff(nil, e.New)
+	}
+	return Diff(e.Old, e.New)
+}
+
+type subscriber struct {
+	notify func(any) bool
+}
+
+var subscribers = struct {
+	sync.RWMutex
+	names map[string][]*subscriber
+}{
+	names: make(map[string][]*subscriber),
+}
+
+// Subscribe registers a handler which is called synchronously after RegCallback[T] commits a new value of name,
+// so that components like connection pools can rebuild themselves on config change, handler should return quickly,
+// otherwise use `SubscribeChan`. Returns a function to unsubscribe.
+//
+//	unsubscribe := dynconf.Subscribe("degrade", func(e dynconf.Event[bool]) {
+//	    log.Println(e.Old, e.New)
+//	})
+//	defer unsubscribe()
+func Subscribe[T any](name string, handler func(Event[T])) (unsubscribe func()) {
+	s := &subscriber{
+		notify: func(v any) bool {
+			event, ok := v.(Event[T])
+			if ok {
+				handler(event)
+			}
+			return ok
+		},
+	}
+	subscribers.Lock()
+	subscribers.names[name] = append(subscribers.names[name], s)
+	subscribers.Unlock()
+	return func() {
+		subscribers.Lock()
+		defer subscribers.Unlock()
+		ss := subscribers.names[name]
+		for i := range ss {
+			if ss[i] == s {
+				subscribers.names[name] = append(ss[:i:i], ss[i+1:]...)
+				break
+			}
+		}
+		if len(subscribers.names[name]) == 0 {
+			delete(subscribers.names, name)
+		}
+	}
+}
+
+// SubscribeChan is like Subscribe, but events are sent to the returned channel with buffer size, events are dropped
+// if the buffer is full, the channel is closed after unsubscribing
+func SubscribeChan[T any](name string, size int) (events <-chan Event[T], unsubscribe func()) {
+	ch := make(chan Event[T], size)
+	var lock sync.Mutex
+	var closed bool
+	unsub := Subscribe(name, func(event Event[T]) {
+		lock.Lock()
+		defer lock.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- event:
+		default:
+			Logger().Warn("subscriber channel is full, event dropped", zap.String("name", name), zap.Int64("revision", event.Revision))
+		}
+	})
+	return ch, func() {
+		unsub()
+		lock.Lock()
+		defer lock.Unlock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
+}
+
+// publish sends event to subscribers of name, panics of handlers are recovered and logged
+func publish[T any](event Event[T]) {
+	subscribers.RLock()
+	ss := make([]*subscriber, len(subscribers.names[event.Name]))
+	copy(ss, subscribers.names[event.Name])
+	subscribers.RUnlock()
+	for _, s := range ss {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					Logger().Error("subscriber panic", zap.String("name", event.Name), zap.Int64("revision", event.Revision), zap.Error(fmt.Errorf("%v", r)))
+				}
+			}()
+			s.notify(event)
+		}()
+	}
+}
@@ -0,0 +1,53 @@
+package dynconf_test
+
+import (
+	"testing"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribe(t *testing.T) {
+	cb := dynconf.NewRegCallback[RateLimit]("subscribe_rate_limit")
+	var events []dynconf.Event[RateLimit]
+	unsubscribe := dynconf.Subscribe("subscribe_rate_limit", func(e dynconf.Event[RateLimit]) {
+		events = append(events, e)
+	})
+	dynconf.Subscribe("subscribe_rate_limit", func(e dynconf.Event[bool]) {
+		t.Fatal("type mismatched subscriber should not be called")
+	})
+	dynconf.Subscribe("subscribe_rate_limit", func(e dynconf.Event[RateLimit]) {
+		panic("recovered")
+	})
+	ch, unsubscribeChan := dynconf.SubscribeChan[RateLimit]("subscribe_rate_limit", 1)
+
+	err := cb.Callback("subscribe:rate_limit", `{"name": "subscribe_rate_limit", "value": {"rate": 1, "burst": 1}}`)
+	assert.Nilf(t, err, "first")
+	err = cb.Callback("subscribe:rate_limit", `{"name": "subscribe_rate_limit", "value": {"rate": 2, "burst": 1}}`)
+	assert.Nilf(t, err, "second")
+	err = cb.Callback("subscribe:rate_limit", `{"name": "subscribe_rate_limit", "value": {"rate": "bad"}}`)
+	assert.NotNilf(t, err, "invalid data not published")
+
+	assert.Lenf(t, events, 2, "events")
+	assert.Falsef(t, events[0].OldFound, "first time")
+	assert.Equalf(t, RateLimit{Rate: 1, Burst: 1}, events[0].New, "first new")
+	assert.Truef(t, events[1].OldFound, "old found")
+	assert.Equalf(t, RateLimit{Rate: 1, Burst: 1}, events[1].Old, "old")
+	assert.Equalf(t, RateLimit{Rate: 2, Burst: 1}, events[1].New, "new")
+	assert.Equalf(t, "subscribe:rate_limit", events[1].SourceKey, "source key")
+	assert.Equalf(t, events[0].Revision+1, events[1].Revision, "revision")
+	ops, err := events[1].Diff()
+	assert.Nilf(t, err, "diff")
+	assert.Equalf(t, []dynconf.Operation{{Op: "replace", Path: "/rate", Value: float64(2), Old: float64(1)}}, ops, "diff")
+
+	e := <-ch
+	assert.Equalf(t, RateLimit{Rate: 1, Burst: 1}, e.New, "chan buffered first event, second dropped")
+	unsubscribeChan()
+	_, ok := <-ch
+	assert.Falsef(t, ok, "chan closed")
+
+	unsubscribe()
+	err = cb.Callback("subscribe:rate_limit", `{"name": "subscribe_rate_limit", "value": {"rate": 3, "burst": 1}}`)
+	assert.Nilf(t, err, "third")
+	assert.Lenf(t, events, 2, "unsubscribed")
+}
@@ -0,0 +1,387 @@
+package dynconf
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// Throttle is the debounce and rate limit policy of applying data to the callback registered with a key, data of
+// the same sourceKey are coalesced, i.e. only the latest data within the window is applied, the first data of a
+// sourceKey is applied immediately, e.g.
+//
+//	{"debounce": "500ms", "max_wait": "5s", "min_interval": "1s"}
+//
+// NOTE: the delayed data is applied asynchronously with the context of the listener and the failures are logged, but
+// callbacks implementing TxCallback(e.g. RegCallback) are still prepared synchronously, so that invalid data is reported
+// to the listener; data in a batch of multiple changes bypasses throttle, see `ExecuteBatch`. The delayed data is tracked by each listener
+// instance, see `ListenerOptions.FlushThrottles`
+type Throttle struct {
+	// Debounce delays applying until no new data arrived within the window
+	Debounce caddy.Duration `json:"debounce,omitempty"`
+	// MaxWait is the max delay of debounce, which avoids starving if data keeps arriving, 0 means no limit
+	MaxWait caddy.Duration `json:"max_wait,omitempty"`
+	// MinInterval is the min interval between two applies, i.e. the max apply rate is 1/MinInterval
+	MinInterval caddy.Duration `json:"min_interval,omitempty"`
+}
+
+// IsZero reports whether t is the zero value, i.e. no throttling
+func (t Throttle) IsZero() bool {
+	return t.Debounce <= 0 && t.MinInterval <= 0
+}
+
+// ThrottleStats is the statistics of throttled data of a callback key
+type ThrottleStats struct {
+	// Delayed is the number of data delayed by debounce or rate limit
+	Delayed uint64 `json:"delayed"`
+	// Coalesced is the number of data dropped in favor of newer data before being applied
+	Coalesced uint64 `json:"coalesced"`
+	// Dropped is the number of delayed data dropped since the listener has been cleaned up
+	Dropped uint64 `json:"dropped"`
+	// Applied is the number of data applied successfully
+	Applied uint64 `json:"applied"`
+	// Failed is the number of data failed to apply
+	Failed uint64 `json:"failed"`
+}
+
+type throttleKey struct {
+	scope     uint64 // the listener instance, see `withThrottleScope`
+	key       string
+	sourceKey string
+}
+
+// errCoalesced is reported to pendingApplies if the delayed data is dropped in favor of newer data
+var errCoalesced = errors.New("coalesced by newer data")
+
+type throttleScopeKey struct{}
+
+// nextThrottleScope allocates the scopes of throttlers, 0 is the scope of data not delivered by listeners
+var nextThrottleScope uint64
+
+// withThrottleScope returns ctx with the scope of throttlers, i.e. the data delayed within the scope is tracked by
+// throttlers of the scope, which are flushed together, see `flushThrottles`
+func withThrottleScope(ctx context.Context, scope uint64) context.Context {
+	return context.WithValue(ctx, throttleScopeKey{}, scope)
+}
+
+func throttleScope(ctx context.Context) uint64 {
+	scope, _ := ctx.Value(throttleScopeKey{}).(uint64)
+	return scope
+}
+
+var throttles = struct {
+	sync.RWMutex
+	throttlers map[throttleKey]*throttler
+	stats      map[string]*ThrottleStats
+}{
+	throttlers: make(map[throttleKey]*throttler),
+	stats:      make(map[string]*ThrottleStats),
+}
+
+// SetThrottle set the throttle of the callback registered with key, zero value removes it, and the delayed data
+// is applied immediately
+func SetThrottle(key string, t Throttle) {
+	if !t.IsZero() {
+		setDefaults(func(s *settings) { s.throttles[key] = t })
+		return
+	}
+	setDefaults(func(s *settings) { delete(s.throttles, key) })
+	flushThrottles(func(tk throttleKey) bool {
+		return tk.key == key
+	})
+}
+
+// GetThrottle returns the default throttle of the callback registered with key, NOTE: the throttle set by the config
+// executing the callback takes precedence
+func GetThrottle(key string) (Throttle, bool) {
+	return getDefaults().getThrottle(key)
+}
+
+// GetThrottleStats returns the statistics of throttled data keyed by callback key
+func GetThrottleStats() map[string]ThrottleStats {
+	throttles.RLock()
+	defer throttles.RUnlock()
+	stats := make(map[string]ThrottleStats, len(throttles.stats))
+	for key, s := range throttles.stats {
+		stats[key] = ThrottleStats{
+			Delayed:   atomic.LoadUint64(&s.Delayed),
+			Coalesced: atomic.LoadUint64(&s.Coalesced),
+			Dropped:   atomic.LoadUint64(&s.Dropped),
+			Applied:   atomic.LoadUint64(&s.Applied),
+			Failed:    atomic.LoadUint64(&s.Failed),
+		}
+	}
+	return stats
+}
+
+// throttle applies with the timeout of `CallbackTimeout`, according to the throttle of key if set, the data delayed is
+// added to pending, which is notified after the data is applied or dropped
+func throttle(ctx context.Context, key, sourceKey string, apply func(ctx context.Context) error, pending *pendingApplies) error {
+	s := settingsOf(ctx)
+	timeout := s.callbackTimeout(ctx, key)
+	t, ok := s.getThrottle(key)
+	if !ok {
+		// NOTE: the throttler may be still flushing the data delayed before the throttle was removed
+		return applyUnthrottled(ctx, key, sourceKey, apply)
+	}
+	return getThrottler(throttleScope(ctx), key, sourceKey).submit(ctx, t, timeout, apply, pending)
+}
+
+// applyUnthrottled applies immediately regardless of the throttle of key, and discards the delayed data of the same
+// sourceKey, so that it does not overwrite the data applied later
+func applyUnthrottled(ctx context.Context, key, sourceKey string, apply func(ctx context.Context) error) error {
+	timeout := CallbackTimeout(ctx, key)
+	throttles.RLock()
+	th, ok := throttles.throttlers[throttleKey{scope: throttleScope(ctx), key: key, sourceKey: sourceKey}]
+	throttles.RUnlock()
+	if !ok {
+		return applyObserved(ctx, key, timeout, apply)
+	}
+	th.applying.Lock()
+	defer th.applying.Unlock()
+	th.mu.Lock()
+	var notifies []*pendingApplies
+	if th.pending != nil {
+		notifies = th.notifies
+		th.pending, th.ctx, th.notifies = nil, nil, nil
+		atomic.AddUint64(&th.stats.Coalesced, 1)
+		dynconfMetrics.throttled.WithLabelValues(th.key, "coalesced").Inc()
+	}
+	th.last = time.Now()
+	th.mu.Unlock()
+	notifyApplies(notifies, errCoalesced)
+	return th.done(applyObserved(ctx, key, timeout, apply))
+}
+
+// FlushThrottles applies all delayed data immediately and waits until applied, the data whose listener has been
+// cleaned up is dropped(i.e. its context is canceled), used on shutdown so that no data is applied later
+func FlushThrottles() {
+	flushThrottles(func(throttleKey) bool { return true })
+}
+
+// flushThrottles is like FlushThrottles, but only flushes the throttlers matched
+//
+// NOTE: the throttlers are removed after flushed, so that the data submitted meanwhile is applied by the same
+// throttler, i.e. after the delayed data
+func flushThrottles(match func(throttleKey) bool) {
+	throttles.RLock()
+	ths := make(map[throttleKey]*throttler)
+	for tk, th := range throttles.throttlers {
+		if match(tk) {
+			ths[tk] = th
+		}
+	}
+	throttles.RUnlock()
+	for _, th := range ths {
+		th.stop()
+	}
+	throttles.Lock()
+	for tk, th := range ths {
+		if throttles.throttlers[tk] == th {
+			delete(throttles.throttlers, tk)
+		}
+	}
+	throttles.Unlock()
+}
+
+// applyObserved applies with timeout, and records the invocation of callback registered with key as metrics
+func applyObserved(ctx context.Context, key string, timeout time.Duration, apply func(ctx context.Context) error) error {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := apply(ctx)
+	observeCallback(key, start, err)
+	return err
+}
+
+func getThrottler(scope uint64, key, sourceKey string) *throttler {
+	tk := throttleKey{scope: scope, key: key, sourceKey: sourceKey}
+	throttles.Lock()
+	defer throttles.Unlock()
+	th, ok := throttles.throttlers[tk]
+	if !ok {
+		stats, ok := throttles.stats[key]
+		if !ok {
+			stats = new(ThrottleStats)
+			throttles.stats[key] = stats
+		}
+		th = &throttler{throttleKey: tk, stats: stats}
+		throttles.throttlers[tk] = th
+	}
+	return th
+}
+
+// throttler delays and coalesces data of a sourceKey applied to the callback registered with key
+type throttler struct {
+	throttleKey
+	stats *ThrottleStats
+
+	applying sync.Mutex // NOTE: serializes applies, so that the latest data is always applied last
+
+	mu       sync.Mutex
+	pending  func(ctx context.Context) error
+	ctx      context.Context   // the context of the pending data, canceled when the listener is cleaned up
+	notifies []*pendingApplies // notified after the pending data is applied or dropped
+	timeout  time.Duration
+	first    time.Time // the time when the first pending data arrived
+	due      time.Time
+	last     time.Time // the time of the last apply
+	timer    *time.Timer
+	stopped  bool // removed by FlushThrottles, data submitted later is applied immediately
+}
+
+func (th *throttler) submit(ctx context.Context, t Throttle, timeout time.Duration, apply func(ctx context.Context) error, pending *pendingApplies) error {
+	th.mu.Lock()
+	now := time.Now()
+	var coalesced []*pendingApplies
+	if th.pending == nil {
+		th.first = now
+	} else {
+		coalesced = th.notifies
+		atomic.AddUint64(&th.stats.Coalesced, 1)
+		dynconfMetrics.throttled.WithLabelValues(th.key, "coalesced").Inc()
+	}
+	due := now.Add(time.Duration(t.Debounce))
+	if t.MaxWait > 0 {
+		if max := th.first.Add(time.Duration(t.MaxWait)); due.After(max) {
+			due = max
+		}
+	}
+	if next := th.last.Add(time.Duration(t.MinInterval)); next.After(due) {
+		due = next
+	}
+	// apply immediately if not delayed, so that the error is returned to the listener; the first data(e.g. the default
+	// or the initial load of a listener) is never delayed, otherwise the key is not ready until the window elapsed
+	if th.stopped || th.pending == nil && (th.last.IsZero() || !due.After(now)) {
+		th.last = now
+		th.mu.Unlock()
+		th.applying.Lock()
+		defer th.applying.Unlock()
+		return th.done(applyObserved(ctx, th.key, timeout, apply))
+	}
+	atomic.AddUint64(&th.stats.Delayed, 1)
+	dynconfMetrics.throttled.WithLabelValues(th.key, "delayed").Inc()
+	th.pending, th.ctx, th.timeout, th.due = apply, ctx, timeout, due
+	th.notifies = nil
+	if pending != nil {
+		th.notifies = []*pendingApplies{pending.add()}
+	}
+	if th.timer == nil {
+		th.timer = time.AfterFunc(due.Sub(now), th.fire)
+	} else {
+		th.timer.Reset(due.Sub(now))
+	}
+	th.mu.Unlock()
+	notifyApplies(coalesced, errCoalesced)
+	return nil
+}
+
+func (th *throttler) fire() {
+	th.applying.Lock()
+	defer th.applying.Unlock()
+	th.mu.Lock()
+	if th.pending == nil {
+		th.mu.Unlock()
+		return
+	}
+	if wait := time.Until(th.due); wait > 0 {
+		th.timer.Reset(wait)
+		th.mu.Unlock()
+		return
+	}
+	th.applyPending()
+}
+
+// applyPending applies the pending data with th.mu locked, and unlocks it
+func (th *throttler) applyPending() {
+	ctx, apply, timeout, notifies := th.ctx, th.pending, th.timeout, th.notifies
+	th.pending, th.ctx, th.notifies = nil, nil, nil
+	th.last = time.Now()
+	th.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		atomic.AddUint64(&th.stats.Dropped, 1)
+		dynconfMetrics.throttled.WithLabelValues(th.key, "dropped").Inc()
+		notifyApplies(notifies, err)
+		settingsOf(ctx).getLogger().Warn("throttled data dropped since listener cleaned up",
+			zap.String("key", th.key),
+			zap.String("source_key", th.sourceKey))
+		return
+	}
+	err := th.done(applyObserved(ctx, th.key, timeout, apply))
+	notifyApplies(notifies, err)
+	if err != nil {
+		settingsOf(ctx).getLogger().Error("apply throttled data failed",
+			zap.String("key", th.key),
+			zap.String("source_key", th.sourceKey),
+			zap.Error(err))
+	}
+}
+
+// stop stops the timer and applies the pending data immediately
+func (th *throttler) stop() {
+	th.applying.Lock()
+	defer th.applying.Unlock()
+	th.mu.Lock()
+	th.stopped = true
+	if th.timer != nil {
+		th.timer.Stop()
+	}
+	if th.pending == nil {
+		th.mu.Unlock()
+		return
+	}
+	th.applyPending()
+}
+
+func (th *throttler) done(err error) error {
+	if err != nil {
+		atomic.AddUint64(&th.stats.Failed, 1)
+	} else {
+		atomic.AddUint64(&th.stats.Applied, 1)
+	}
+	return err
+}
+
+// pendingApplies calls fn once after the data is applied successfully by all callbacks, including the data delayed
+// by throttles, fn is never called if any of them failed or dropped, see `execute`
+type pendingApplies struct {
+	mu     sync.Mutex
+	n      int // the number of delayed data not applied yet, and 1 for the caller
+	failed bool
+	fn     func()
+}
+
+func newPendingApplies(fn func()) *pendingApplies {
+	return &pendingApplies{n: 1, fn: fn}
+}
+
+// add adds a delayed data
+func (p *pendingApplies) add() *pendingApplies {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.n++
+	return p
+}
+
+// done is called after a delayed data is applied or dropped, or by the caller after all callbacks executed
+func (p *pendingApplies) done(err error) {
+	p.mu.Lock()
+	p.n--
+	p.failed = p.failed || err != nil
+	call := p.n == 0 && !p.failed && p.fn != nil
+	p.mu.Unlock()
+	if call {
+		p.fn()
+	}
+}
+
+func notifyApplies(notifies []*pendingApplies, err error) {
+	for _, p := range notifies {
+		p.done(err)
+	}
+}
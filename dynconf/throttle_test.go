@@ -0,0 +1,207 @@
+package dynconf_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+type recorder struct {
+	sync.Mutex
+	datas []string
+}
+
+func (r *recorder) Callback(sourceKey, data string) error {
+	r.Lock()
+	defer r.Unlock()
+	r.datas = append(r.datas, data)
+	return nil
+}
+
+func (r *recorder) Datas() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string(nil), r.datas...)
+}
+
+func TestThrottle(t *testing.T) {
+	ctx := context.Background()
+	debounced := new(recorder)
+	typemap.MustRegister[dynconf.Callback](ctx, "throttle:debounce", debounced)
+	dynconf.SetThrottle("throttle:debounce", dynconf.Throttle{Debounce: caddy.Duration(50 * time.Millisecond)})
+	defer dynconf.SetThrottle("throttle:debounce", dynconf.Throttle{})
+	callbacks := []typemap.Ref[dynconf.Callback]{{Name: "throttle:debounce"}}
+	for i := 1; i <= 5; i++ {
+		err := dynconf.Execute(ctx, callbacks, "throttle", fmt.Sprint(i))
+		assert.Nilf(t, err, "delayed")
+	}
+	assert.Equalf(t, []string{"1"}, debounced.Datas(), "the first is applied immediately")
+	time.Sleep(200 * time.Millisecond)
+	assert.Equalf(t, []string{"1", "5"}, debounced.Datas(), "only the latest is applied")
+	stats := dynconf.GetThrottleStats()["throttle:debounce"]
+	assert.Equalf(t, dynconf.ThrottleStats{Delayed: 4, Coalesced: 3, Applied: 2}, stats, "stats")
+
+	limited := new(recorder)
+	typemap.MustRegister[dynconf.Callback](ctx, "throttle:limit", limited)
+	dynconf.SetThrottle("throttle:limit", dynconf.Throttle{MinInterval: caddy.Duration(100 * time.Millisecond)})
+	defer dynconf.SetThrottle("throttle:limit", dynconf.Throttle{})
+	callbacks = []typemap.Ref[dynconf.Callback]{{Name: "throttle:limit"}}
+	for i := 1; i <= 4; i++ {
+		err := dynconf.Execute(ctx, callbacks, "throttle", fmt.Sprint(i))
+		assert.Nilf(t, err, "limited")
+	}
+	assert.Equalf(t, []string{"1"}, limited.Datas(), "the first is applied immediately")
+	time.Sleep(300 * time.Millisecond)
+	assert.Equalf(t, []string{"1", "4"}, limited.Datas(), "the latest is applied after min interval")
+
+	cb := dynconf.NewRegCallback[RateLimit]("throttle_rate_limit")
+	typemap.MustRegister[dynconf.Callback](ctx, "throttle:rate_limit", cb)
+	dynconf.SetThrottle("throttle:rate_limit", dynconf.Throttle{Debounce: caddy.Duration(time.Hour)})
+	callbacks = []typemap.Ref[dynconf.Callback]{{Name: "throttle:rate_limit"}}
+	err := dynconf.Execute(ctx, callbacks, "throttle", `{"name": "throttle_rate_limit", "value": {"rate": "fast"}}`)
+	assert.NotNilf(t, err, "prepared synchronously")
+	err = dynconf.Execute(ctx, callbacks, "throttle", `{"name": "throttle_rate_limit", "value": {"rate": 1, "burst": 1}}`)
+	assert.Nilf(t, err, "the first is committed immediately")
+	err = dynconf.Execute(ctx, callbacks, "throttle", `{"name": "throttle_rate_limit", "value": {"rate": 3, "burst": 3}}`)
+	assert.Nilf(t, err, "delayed commit")
+	value, err := typemap.Get[RateLimit](ctx, "throttle_rate_limit")
+	assert.Nilf(t, err, "the first applied")
+	assert.Equalf(t, RateLimit{Rate: 1, Burst: 1}, value, "not applied yet")
+	err = dynconf.ExecuteBatch(ctx, []dynconf.Change{{
+		SourceKey: "throttle",
+		Data:      `{"name": "throttle_rate_limit", "value": {"rate": 4, "burst": 4}}`,
+		Callbacks: callbacks,
+	}})
+	assert.Nilf(t, err, "batch of a single change delayed")
+	value, _ = typemap.Get[RateLimit](ctx, "throttle_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 1, Burst: 1}, value, "not applied yet")
+	err = dynconf.ExecuteBatch(ctx, []dynconf.Change{{
+		SourceKey: "throttle",
+		Data:      `{"name": "throttle_rate_limit", "value": {"rate": 2, "burst": 2}}`,
+		Callbacks: callbacks,
+	}, {
+		SourceKey: "throttle:batch",
+		Data:      "batch",
+		Callbacks: []typemap.Ref[dynconf.Callback]{{Name: "throttle:limit"}},
+	}})
+	assert.Nilf(t, err, "batch of multiple changes bypasses throttle")
+	assert.Equalf(t, []string{"1", "4", "batch"}, limited.Datas(), "batch applied immediately")
+	value, err = typemap.Get[RateLimit](ctx, "throttle_rate_limit")
+	assert.Nilf(t, err, "batch applied immediately")
+	assert.Equalf(t, RateLimit{Rate: 2, Burst: 2}, value, "batch applied immediately")
+	dynconf.SetThrottle("throttle:rate_limit", dynconf.Throttle{})
+	time.Sleep(50 * time.Millisecond)
+	value, _ = typemap.Get[RateLimit](ctx, "throttle_rate_limit")
+	assert.Equalf(t, RateLimit{Rate: 2, Burst: 2}, value, "delayed data discarded by batch")
+}
+
+func TestFlushThrottles(t *testing.T) {
+	ctx := context.Background()
+	flushed := new(recorder)
+	typemap.MustRegister[dynconf.Callback](ctx, "throttle:flush", flushed)
+	dynconf.SetThrottle("throttle:flush", dynconf.Throttle{Debounce: caddy.Duration(time.Hour)})
+	defer dynconf.SetThrottle("throttle:flush", dynconf.Throttle{})
+	callbacks := []typemap.Ref[dynconf.Callback]{{Name: "throttle:flush"}}
+
+	listenerCtx, cancel := context.WithCancel(ctx)
+	err := dynconf.Execute(listenerCtx, callbacks, "canceled", "1")
+	assert.Nilf(t, err, "applied immediately")
+	err = dynconf.Execute(listenerCtx, callbacks, "canceled", "2")
+	assert.Nilf(t, err, "delayed")
+	cancel()
+	err = dynconf.Execute(ctx, callbacks, "flush", "3")
+	assert.Nilf(t, err, "applied immediately")
+	err = dynconf.Execute(ctx, callbacks, "flush", "4")
+	assert.Nilf(t, err, "delayed")
+	assert.Equalf(t, []string{"1", "3"}, flushed.Datas(), "not applied yet")
+	dynconf.FlushThrottles()
+	assert.Equalf(t, []string{"1", "3", "4"}, flushed.Datas(), "flushed, and data of canceled listener dropped")
+	err = dynconf.Execute(ctx, callbacks, "flush", "5")
+	assert.Nilf(t, err, "applied immediately")
+	err = dynconf.Execute(ctx, callbacks, "flush", "6")
+	assert.Nilf(t, err, "delayed")
+	assert.Equalf(t, []string{"1", "3", "4", "5"}, flushed.Datas(), "throttled again after flushed")
+	dynconf.FlushThrottles()
+	assert.Equalf(t, []string{"1", "3", "4", "5", "6"}, flushed.Datas(), "flushed again")
+	stats := dynconf.GetThrottleStats()["throttle:flush"]
+	assert.Equalf(t, dynconf.ThrottleStats{Delayed: 3, Dropped: 1, Applied: 5}, stats, "stats")
+
+	err = dynconf.Execute(ctx, callbacks, "flush", "7")
+	assert.Nilf(t, err, "delayed")
+	dynconf.SetThrottle("throttle:flush", dynconf.Throttle{})
+	assert.Equalf(t, []string{"1", "3", "4", "5", "6", "7"}, flushed.Datas(), "flushed once the throttle removed")
+	err = dynconf.Execute(ctx, callbacks, "flush", "8")
+	assert.Nilf(t, err, "not throttled")
+	assert.Equalf(t, []string{"1", "3", "4", "5", "6", "7", "8"}, flushed.Datas(), "not throttled")
+}
+
+func TestThrottleDefault(t *testing.T) {
+	ctx := context.Background()
+	typemap.MustRegister[dynconf.Callback](ctx, "throttle:default", dynconf.NewRegCallback[RateLimit]("throttle_default"))
+	defer dynconf.SetThrottle("throttle:default", dynconf.Throttle{})
+	caddyCtx, cancel := caddy.NewContext(caddy.Context{Context: ctx})
+	defer cancel()
+	callbacks := &dynconf.Callbacks{}
+	err := json.Unmarshal([]byte(`{
+		"defaults": [
+			{
+				"keys": ["throttle:default"],
+				"throttle": {"debounce": "1s"},
+				"default": {"name": "throttle_default", "value": {"rate": 1, "burst": 1}}
+			}
+		]
+	}`), callbacks)
+	assert.Nilf(t, err, "unmarshal")
+	err = callbacks.Provision(caddyCtx)
+	assert.Nilf(t, err, "provision")
+	value, err := typemap.Get[RateLimit](ctx, "throttle_default")
+	assert.Nilf(t, err, "default applied immediately")
+	assert.Equalf(t, RateLimit{Rate: 1, Burst: 1}, value, "default applied immediately")
+}
+
+func TestListenerOptionsFlushThrottles(t *testing.T) {
+	ctx := context.Background()
+	scoped := new(recorder)
+	typemap.MustRegister[dynconf.Callback](ctx, "throttle:scoped", scoped)
+	dynconf.SetThrottle("throttle:scoped", dynconf.Throttle{Debounce: caddy.Duration(time.Hour)})
+	defer dynconf.SetThrottle("throttle:scoped", dynconf.Throttle{})
+	dir := t.TempDir()
+	err := dynconf.SetSnapshotDir(dir, 0)
+	assert.Nilf(t, err, "set snapshot dir")
+	defer dynconf.SetSnapshotDir("", 0)
+	callbacks := []typemap.Ref[dynconf.Callback]{{Name: "throttle:scoped"}}
+
+	var first, second dynconf.ListenerOptions
+	err = first.Execute(ctx, callbacks, "scoped", "1")
+	assert.Nilf(t, err, "applied immediately")
+	err = second.Execute(ctx, callbacks, "scoped", "2")
+	assert.Nilf(t, err, "applied immediately")
+	assert.Truef(t, first.Delivered("scoped"), "the initial data delivered")
+	assert.Truef(t, second.Delivered("scoped"), "the initial data delivered")
+	err = first.Execute(ctx, callbacks, "scoped", "3")
+	assert.Nilf(t, err, "delayed")
+	err = second.Execute(ctx, callbacks, "scoped", "4")
+	assert.Nilf(t, err, "delayed")
+	assert.Equalf(t, []string{"1", "2"}, scoped.Datas(), "not applied yet")
+	snapshots, _ := dynconf.LoadSnapshots()
+	assert.Equalf(t, 1, len(snapshots), "no snapshot until applied")
+	assert.Equalf(t, "2", snapshots[0].Data, "no snapshot until applied")
+
+	first.FlushThrottles()
+	assert.Equalf(t, []string{"1", "2", "3"}, scoped.Datas(), "only the data of first flushed")
+	snapshots, _ = dynconf.LoadSnapshots()
+	assert.Equalf(t, "3", snapshots[0].Data, "snapshot after applied")
+
+	second.FlushThrottles()
+	assert.Equalf(t, []string{"1", "2", "3", "4"}, scoped.Datas(), "the data of second flushed")
+	snapshots, _ = dynconf.LoadSnapshots()
+	assert.Equalf(t, "4", snapshots[0].Data, "snapshot after applied")
+}
@@ -0,0 +1,71 @@
+package dynconf
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultCallbackTimeout is the default timeout of executing a callback
+const DefaultCallbackTimeout = 3 * time.Second
+
+// SetDefaultCallbackTimeout set the default timeout of executing callbacks, timeout <= 0 means DefaultCallbackTimeout
+func SetDefaultCallbackTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultCallbackTimeout
+	}
+	setDefaults(func(s *settings) { s.timeout = timeout })
+}
+
+// SetCallbackTimeout set the timeout of executing the callback registered with key, timeout <= 0 removes it
+func SetCallbackTimeout(key string, timeout time.Duration) {
+	setDefaults(func(s *settings) {
+		if timeout <= 0 {
+			delete(s.timeouts, key)
+			return
+		}
+		s.timeouts[key] = timeout
+	})
+}
+
+type callbackTimeoutKey struct{}
+
+// WithCallbackTimeout returns a context carrying the timeout of callbacks executed with it, used by listeners
+// to override the default timeout
+func WithCallbackTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	if timeout <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, callbackTimeoutKey{}, timeout)
+}
+
+// CallbackTimeout returns the timeout of executing the callback registered with key, in the order of:
+// timeout set for key(by `SetCallbackTimeout` or the config), timeout carried by ctx(see `WithCallbackTimeout`) and the
+// default timeout, NOTE: the settings of the config carried by ctx are used if any
+func CallbackTimeout(ctx context.Context, key string) time.Duration {
+	return settingsOf(ctx).callbackTimeout(ctx, key)
+}
+
+// defaultCallbackTimeout returns the default timeout, used when the callback is executed without context
+func defaultCallbackTimeout() time.Duration {
+	return settingsOf(context.Background()).timeout
+}
+
+// callCallback executes the callback registered with key, with the timeout of `CallbackTimeout` and the throttle
+// of key(see `SetThrottle`), the data delayed by throttle is added to pending if not nil
+func callCallback(ctx context.Context, key string, cb Callback, sourceKey, data string, pending *pendingApplies) error {
+	apply := func(ctx context.Context) error {
+		return WithContext(cb).CallbackContext(ctx, sourceKey, data)
+	}
+	if _, ok := settingsOf(ctx).getThrottle(key); ok {
+		if tx, ok := cb.(TxCallback); ok {
+			start := time.Now()
+			commit, err := tx.Prepare(ctx, sourceKey, data)
+			if err != nil {
+				observeCallback(key, start, err)
+				return err
+			}
+			apply = commit
+		}
+	}
+	return throttle(ctx, key, sourceKey, apply, pending)
+}
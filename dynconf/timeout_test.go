@@ -0,0 +1,47 @@
+package dynconf_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ccmonky/caddy-config/dynconf"
+	"github.com/ccmonky/typemap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallbackTimeout(t *testing.T) {
+	ctx := context.Background()
+	var timeouts []time.Duration
+	typemap.MustRegister[dynconf.Callback](ctx, "timeout:record", dynconf.ContextCallbackFunc(func(ctx context.Context, sourceKey, data string) error {
+		deadline, ok := ctx.Deadline()
+		assert.Truef(t, ok, "deadline")
+		timeouts = append(timeouts, time.Until(deadline).Round(time.Second))
+		return nil
+	}))
+	var executed int
+	typemap.MustRegister[dynconf.Callback](ctx, "timeout:plain", dynconf.CallbackFunc(func(sourceKey, data string) error {
+		executed++
+		return nil
+	}))
+	callbacks := []typemap.Ref[dynconf.Callback]{{Name: "timeout:record"}}
+
+	err := dynconf.Execute(ctx, callbacks, "timeout", "data")
+	assert.Nilf(t, err, "default")
+	err = dynconf.Execute(dynconf.WithCallbackTimeout(ctx, 10*time.Second), callbacks, "timeout", "data")
+	assert.Nilf(t, err, "listener")
+	dynconf.SetCallbackTimeout("timeout:record", 20*time.Second)
+	defer dynconf.SetCallbackTimeout("timeout:record", 0)
+	err = dynconf.Execute(dynconf.WithCallbackTimeout(ctx, 10*time.Second), callbacks, "timeout", "data")
+	assert.Nilf(t, err, "key")
+	assert.Equalf(t, []time.Duration{dynconf.DefaultCallbackTimeout, 10 * time.Second, 20 * time.Second}, timeouts, "timeouts")
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = dynconf.Execute(canceled, []typemap.Ref[dynconf.Callback]{{Name: "timeout:plain"}}, "timeout", "data")
+	assert.NotNilf(t, err, "canceled")
+	assert.Equalf(t, 0, executed, "canceled callback not executed")
+	err = dynconf.Execute(ctx, []typemap.Ref[dynconf.Callback]{{Name: "timeout:plain"}}, "timeout", "data")
+	assert.Nilf(t, err, "plain")
+	assert.Equalf(t, 1, executed, "adapted callback executed")
+}
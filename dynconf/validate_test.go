@@ -1,6 +1,7 @@
 package dynconf_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/ccmonky/caddy-config/dynconf"
@@ -49,3 +50,50 @@ func TestDeleteJsonOmitemptyMarker(t *testing.T) {
 	assert.Truef(t, jsonschema.IsValidateFailedError(err), "validate error")
 	assert.Equalf(t, err.Error(), "jsonschema: - value.Embed: a is required\n", "err detail")
 }
+
+type Degrade struct {
+	Rate     int    `json:"rate" jsonschema:"maximum=100" jsonschema_extras:"minimum=0"`
+	Mode     string `json:"mode" jsonschema:"enum=reject,enum=fallback"`
+	Fallback string `json:"fallback,omitempty"`
+}
+
+func TestSchemaConstraints(t *testing.T) {
+	cb := dynconf.NewRegCallback[Degrade]("schema_degrade")
+	err := cb.Callback("schema", `{"name": "schema_degrade", "value": {"rate": 10, "mode": "reject", "fallback": ""}}`)
+	assert.Nilf(t, err, "valid")
+	err = cb.Callback("schema", `{"name": "schema_degrade", "value": {"rate": -5, "mode": "drop", "fallback": ""}}`)
+	var verr *dynconf.ValidationError
+	assert.Truef(t, errors.As(err, &verr), "validation error")
+	assert.Equalf(t, []dynconf.Issue{
+		{Path: "/value/mode", Message: `value.mode must be one of the following: "reject", "fallback"`},
+		{Path: "/value/rate", Message: "Must be greater than or equal to 0"},
+	}, verr.Issues, "tag constraints")
+
+	// cross-field rule: fallback is required if mode is fallback
+	err = dynconf.SetSchema("schema_degrade", []byte(`{
+		"type": "object",
+		"properties": {
+			"rate": {"type": "integer", "minimum": 0},
+			"mode": {"enum": ["reject", "fallback"]}
+		},
+		"required": ["rate", "mode"],
+		"if": {"properties": {"mode": {"const": "fallback"}}},
+		"then": {"properties": {"fallback": {"minLength": 1}}, "required": ["fallback"]}
+	}`))
+	assert.Nilf(t, err, "set schema")
+	defer dynconf.SetSchema("schema_degrade", nil)
+	err = cb.Callback("schema", `{"name": "schema_degrade", "value": {"rate": 10, "mode": "fallback"}}`)
+	assert.Truef(t, errors.As(err, &verr), "validation error")
+	assert.Equalf(t, []dynconf.Issue{
+		{Path: "/value", Message: `Must validate "then" as "if" was valid`},
+		{Path: "/value", Message: "fallback is required"},
+	}, verr.Issues, "cross-field rule")
+	err = cb.Callback("schema", `{"name": "schema_degrade", "value": {"rate": 10, "mode": "fallback", "fallback": "cache"}}`)
+	assert.Nilf(t, err, "cross-field valid")
+	err = cb.Callback("schema", `{"name": "schema_degrade"}`)
+	assert.Truef(t, errors.As(err, &verr), "validation error")
+	assert.Equalf(t, []dynconf.Issue{{Message: "value is required"}}, verr.Issues, "value missing")
+
+	err = dynconf.SetSchema("schema_degrade", []byte(`{"type": 1}`))
+	assert.NotNilf(t, err, "invalid schema")
+}
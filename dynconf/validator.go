@@ -25,36 +25,68 @@ func (rt DefaultRetager) ConvertAny(p interface{}, maker jsonschema.TagMaker) in
 	return retag.ConvertAny(p, maker)
 }
 
+// DeleteJsonOmitemptyMarker returns a tag maker which deletes the `omitempty` marker of json tags, so that all fields are required,
+// the `jsonschema` and `jsonschema_extras` tags are kept to express constraints, e.g.
+//
+//	type RateLimit struct {
+//	    Rate  int    `json:"rate" jsonschema:"maximum=10000" jsonschema_extras:"minimum=0"`
+//	    Burst int    `json:"burst" jsonschema:"minimum=1"`
+//	    Mode  string `json:"mode" jsonschema:"enum=reject,enum=queue"`
+//	}
+//
+// NOTE: zero `minimum` in `jsonschema` tag is omitted by `invopop/jsonschema`, use `jsonschema_extras` instead
 func DeleteJsonOmitemptyMarker() retag.TagMaker {
 	return deleteJsonOmitemptyMarker{}
 }
 
 type deleteJsonOmitemptyMarker struct{}
 
+// schemaTags are the tags used by `invopop/jsonschema` to express constraints
+var schemaTags = []string{"jsonschema", "jsonschema_extras"}
+
 func (m deleteJsonOmitemptyMarker) MakeTag(t reflect.Type, fieldIndex int) reflect.StructTag {
 	field := t.Field(fieldIndex)
 	if strings.HasPrefix(t.Name(), "RegCallback[") { // NOTE: ignore wrapper!
 		return field.Tag
 	}
-	jsonTag, ok := field.Tag.Lookup("json")
-	if !ok {
-		return ""
+	var tags []string
+	if jsonTag, ok := field.Tag.Lookup("json"); ok {
+		if strings.Contains(jsonTag, ",omitempty") {
+			jsonTag = strings.Split(jsonTag, ",")[0]
+		}
+		tags = append(tags, fmt.Sprintf(`json:"%s"`, jsonTag))
 	}
-	if strings.Contains(jsonTag, ",omitempty") {
-		key := strings.Split(jsonTag, ",")[0]
-		return reflect.StructTag(fmt.Sprintf(`json:"%s"`, key))
+	for _, key := range schemaTags {
+		if tag, ok := field.Tag.Lookup(key); ok {
+			tags = append(tags, fmt.Sprintf(`%s:%q`, key, tag))
+		}
 	}
-	return reflect.StructTag(fmt.Sprintf(`json:"%s"`, jsonTag))
+	return reflect.StructTag(strings.Join(tags, " "))
 }
 
 type DefaultGenerator struct{}
 
 func (g DefaultGenerator) Reflect(v interface{}) ([]byte, error) {
-	return json.Marshal(jsgen.Reflect(v))
+	return json.Marshal(reflector.Reflect(v))
 }
 
 func (g DefaultGenerator) ReflectFromType(t reflect.Type) ([]byte, error) {
-	return json.Marshal(jsgen.ReflectFromType(t))
+	return json.Marshal(reflector.ReflectFromType(t))
+}
+
+var reflector = &jsgen.Reflector{
+	Namer: DefinitionName,
+}
+
+// DefinitionName returns the name of type used as key of `$defs`, the name of generic type(e.g. `RegCallback[pkg/path.T]`)
+// is sanitized, otherwise the `$ref` json pointer can not be resolved
+func DefinitionName(t reflect.Type) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, t.Name())
 }
 
 func DefaultValidate(schema, data []byte) error {
@@ -65,15 +97,125 @@ func DefaultValidate(schema, data []byte) error {
 	if result.Valid() {
 		return nil
 	}
+	return resultError(result, "")
+}
+
+// resultError converts the errors of result to SchemaError, prefix is prepended to the json pointer of issues
+func resultError(result *gojsonschema.Result, prefix string) error {
 	detail := ""
+	issues := make([]Issue, 0, len(result.Errors()))
 	for _, desc := range result.Errors() {
 		detail += fmt.Sprintf("- %s\n", desc)
+		issues = append(issues, Issue{
+			Path:    prefix + contextPointer(desc.Context()),
+			Message: desc.Description(),
+		})
+	}
+	sortIssues(issues)
+	return &SchemaError{
+		ValidateFailedError: jsonschema.NewValidateFailedError(detail),
+		Issues:              issues,
 	}
-	return jsonschema.NewValidateFailedError(detail)
+}
+
+// SchemaError is returned by DefaultValidate, with the violations located by json pointer
+type SchemaError struct {
+	*jsonschema.ValidateFailedError
+	Issues []Issue
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.ValidateFailedError
+}
+
+// contextPointer converts the gojsonschema context(e.g. `(root).value.rate`) to json pointer(e.g. `/value/rate`)
+func contextPointer(ctx *gojsonschema.JsonContext) string {
+	if ctx == nil {
+		return ""
+	}
+	const sep = "\x00"
+	parts := strings.Split(ctx.String(sep), sep)
+	if len(parts) > 0 && parts[0] == gojsonschema.STRING_CONTEXT_ROOT {
+		parts = parts[1:]
+	}
+	pointer := ""
+	for _, part := range parts {
+		pointer += "/" + escapePointer(part)
+	}
+	return pointer
 }
 
 var validator *jsonschema.Validator
 
+type override struct {
+	raw    []byte
+	schema *gojsonschema.Schema
+}
+
+// SetSchema set the json schema used to validate the value of RegCallback with typemap instance name, instead of
+// the schema generated from type, so that cross-field rules(e.g. `if`/`then`, `dependentRequired`) can be expressed,
+// empty schema removes it
+func SetSchema(name string, schema []byte) error {
+	if len(schema) == 0 {
+		setDefaults(func(s *settings) { delete(s.overrides, name) })
+		return nil
+	}
+	o, err := newOverride(name, schema)
+	if err != nil {
+		return err
+	}
+	setDefaults(func(s *settings) { s.overrides[name] = o })
+	return nil
+}
+
+func newOverride(name string, schema []byte) (override, error) {
+	s, err := compileSchema(schema)
+	if err != nil {
+		return override{}, fmt.Errorf("invalid schema of %s: %v", name, err)
+	}
+	return override{raw: schema, schema: s}, nil
+}
+
+func compileSchema(schema []byte) (*gojsonschema.Schema, error) {
+	return gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema))
+}
+
+// getOverride returns the schema set by `SetSchema` or the config
+func (s *settings) getOverride(name string) ([]byte, bool) {
+	o, ok := s.overrides[name]
+	return o.raw, ok
+}
+
+// validateOverride validates the value of RegCallback data against the schema set by `SetSchema` or the config,
+// returns false if there is no schema of name
+func (s *settings) validateOverride(name string, data map[string]any) (bool, error) {
+	o, ok := s.overrides[name]
+	if !ok {
+		return false, nil
+	}
+	value, ok := data["value"]
+	if !ok {
+		return true, &SchemaError{
+			ValidateFailedError: jsonschema.NewValidateFailedError("- (root): value is required\n"),
+			Issues:              []Issue{{Message: "value is required"}},
+		}
+	}
+	result, err := o.schema.Validate(gojsonschema.NewGoLoader(value))
+	if err != nil {
+		return true, err
+	}
+	if result.Valid() {
+		return true, nil
+	}
+	return true, resultError(result, "/value")
+}
+
+// generateSchema generates the json schema of value in the same way as validator
+func generateSchema(value any) ([]byte, error) {
+	modified := DefaultRetager{}.ConvertAny(value, DeleteJsonOmitemptyMarker())
+	return DefaultGenerator{}.ReflectFromType(reflect.TypeOf(modified))
+}
+
 func init() {
 	var err error
 	validator, err = jsonschema.NewValidator(
@@ -0,0 +1,140 @@
+package caddyconfig
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/pkg/errors"
+)
+
+// DefaultHealthInterval 后台检查Ready模块的默认间隔
+const DefaultHealthInterval = 10 * time.Second
+
+// HealthReport 后台周期检查的结果:
+//
+// - Ready: 最近一次检查所有Ready模块都就绪，依赖的资源不健康时应摘除流量
+// - Live: 后台检查仍在按周期执行(最近一次检查在3个周期内)，否则说明进程可能已卡死，应重启
+type HealthReport struct {
+	Ready     bool      `json:"ready"`
+	Live      bool      `json:"live"`
+	CheckedAt time.Time `json:"checked_at,omitempty"`
+	// Mods 各Ready模块的检查状态，包括最近一次的错误和最近一次就绪的时间
+	Mods map[string]ReadyStatus `json:"mods"`
+}
+
+// health 后台周期检查Ready模块并缓存结果
+type health struct {
+	interval time.Duration
+	done     chan struct{}
+	stop     sync.Once
+	wg       sync.WaitGroup
+
+	mu        sync.RWMutex
+	ready     bool
+	checkedAt time.Time
+}
+
+// startHealth 启动后台检查，Start时已检查过一次，所以先缓存该结果
+func (c *Config) startHealth() {
+	interval := time.Duration(c.HealthInterval)
+	if interval == 0 {
+		interval = DefaultHealthInterval
+	}
+	h := &health{interval: interval, done: make(chan struct{})}
+	h.update(c.checkedReady())
+	c.health = h
+	if interval < 0 {
+		return
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.update(len(c.checkReady()) == 0)
+			case <-h.done:
+				return
+			}
+		}
+	}()
+}
+
+// stopHealth 停止后台检查并等待正在进行的检查结束，可重复调用
+func (c *Config) stopHealth() {
+	if c.health == nil {
+		return
+	}
+	c.health.stop.Do(func() {
+		close(c.health.done)
+	})
+	c.health.wg.Wait()
+}
+
+// checkedReady 返回最近一次检查是否所有Ready模块都就绪
+func (c *Config) checkedReady() bool {
+	for _, status := range c.ReadyStatuses() {
+		if !status.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *health) update(ready bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready = ready
+	h.checkedAt = time.Now()
+}
+
+// healthReport 返回缓存的检查结果，不会触发检查
+func (c *Config) healthReport() HealthReport {
+	report := HealthReport{Mods: c.ReadyStatuses()}
+	h := c.health
+	if h == nil {
+		return report
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	report.Ready = h.ready
+	report.CheckedAt = h.checkedAt
+	report.Live = h.interval < 0 || time.Since(h.checkedAt) < 3*h.interval
+	return report
+}
+
+// handleHealth 返回缓存的检查结果，`?probe=live`时未存活返回503，否则未就绪返回503，可分别用于liveness和readiness probe
+func (a AdminAPI) handleHealth(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errors.Errorf("method %s not allowed", r.Method),
+		}
+	}
+	report := HealthReport{Mods: make(map[string]ReadyStatus)}
+	c := getRunning()
+	if c != nil {
+		report = c.healthReport()
+	}
+	healthy := report.Ready
+	switch probe := r.URL.Query().Get("probe"); probe {
+	case "", "ready":
+	case "live":
+		healthy = report.Live
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errors.Errorf("unknown probe %s, should be ready or live", probe),
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return json.NewEncoder(w).Encode(report)
+}
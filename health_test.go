@@ -0,0 +1,214 @@
+package caddyconfig_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	caddyconfig "github.com/ccmonky/caddy-config"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	caddy.RegisterModule(Toggle{})
+}
+
+var toggle struct {
+	sync.Mutex
+	err   error
+	block chan struct{}
+}
+
+// setToggle sets the error returned by Toggle.Ready, and blocks Toggle.Ready until unblocked if block
+func setToggle(err error, block bool) (unblock func()) {
+	toggle.Lock()
+	defer toggle.Unlock()
+	toggle.err = err
+	toggle.block = nil
+	if !block {
+		return func() {}
+	}
+	ch := make(chan struct{})
+	toggle.block = ch
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			toggle.Lock()
+			if toggle.block == ch {
+				toggle.block = nil
+			}
+			toggle.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Toggle is ready or not as set by setToggle
+type Toggle struct{}
+
+func (Toggle) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "config.ext.test_toggle",
+		New: func() caddy.Module { return new(Toggle) },
+	}
+}
+
+func (Toggle) Ready() error {
+	toggle.Lock()
+	err, block := toggle.err, toggle.block
+	toggle.Unlock()
+	if block != nil {
+		<-block
+	}
+	return err
+}
+
+func getHealth(t *testing.T, method, query string) (int, caddyconfig.HealthReport, error) {
+	var handler caddy.AdminHandler
+	for _, route := range (caddyconfig.AdminAPI{}).Routes() {
+		if route.Pattern == "/config/health" {
+			handler = route.Handler
+		}
+	}
+	if handler == nil {
+		t.Fatal("/config/health not found")
+	}
+	w := httptest.NewRecorder()
+	err := handler.ServeHTTP(w, httptest.NewRequest(method, "/config/health"+query, nil))
+	var report caddyconfig.HealthReport
+	if err == nil {
+		assert.Nilf(t, json.Unmarshal(w.Body.Bytes(), &report), "get health")
+	}
+	return w.Code, report, err
+}
+
+func TestHealthPeriodicCheck(t *testing.T) {
+	setToggle(nil, false)
+	c := newConfig(t, `{"ext": {"test_toggle": {}}, "health_interval": "30ms"}`)
+	err := c.Start()
+	assert.Nilf(t, err, "start")
+	defer c.Stop()
+
+	code, report, err := getHealth(t, http.MethodGet, "")
+	assert.Nilf(t, err, "get health")
+	assert.Equalf(t, http.StatusOK, code, "ready")
+	assert.Truef(t, report.Ready, "ready")
+	assert.Truef(t, report.Live, "live")
+	assert.Falsef(t, report.CheckedAt.IsZero(), "checked at start")
+	checkedAt := report.CheckedAt
+
+	setToggle(errors.New("toggle down"), false)
+	assert.Eventuallyf(t, func() bool {
+		code, _, _ := getHealth(t, http.MethodGet, "?probe=ready")
+		return code == http.StatusServiceUnavailable
+	}, 3*time.Second, 10*time.Millisecond, "not ready after periodic check")
+	code, report, err = getHealth(t, http.MethodGet, "")
+	assert.Nilf(t, err, "get health")
+	assert.Equalf(t, http.StatusServiceUnavailable, code, "not ready")
+	assert.Falsef(t, report.Ready, "not ready")
+	assert.Truef(t, report.CheckedAt.After(checkedAt), "checked periodically")
+	assert.Equalf(t, "toggle down", report.Mods["config.ext.test_toggle"].LastError, "last error")
+	code, report, err = getHealth(t, http.MethodGet, "?probe=live")
+	assert.Nilf(t, err, "get health")
+	assert.Equalf(t, http.StatusOK, code, "live although not ready")
+	assert.Truef(t, report.Live, "live")
+
+	setToggle(nil, false)
+	assert.Eventuallyf(t, func() bool {
+		code, _, _ := getHealth(t, http.MethodGet, "")
+		return code == http.StatusOK
+	}, 3*time.Second, 10*time.Millisecond, "ready again after periodic check")
+}
+
+func TestHealthLive(t *testing.T) {
+	setToggle(nil, false)
+	c := newConfig(t, `{"ext": {"test_toggle": {}}, "health_interval": "30ms"}`)
+	err := c.Start()
+	assert.Nilf(t, err, "start")
+	defer c.Stop()
+
+	// the periodic check is stuck, so not live after 3 intervals since the last check
+	unblock := setToggle(nil, true)
+	defer unblock()
+	assert.Eventuallyf(t, func() bool {
+		code, _, _ := getHealth(t, http.MethodGet, "?probe=live")
+		return code == http.StatusServiceUnavailable
+	}, 3*time.Second, 10*time.Millisecond, "not live when checks stuck")
+	code, report, err := getHealth(t, http.MethodGet, "?probe=live")
+	assert.Nilf(t, err, "get health")
+	assert.Equalf(t, http.StatusServiceUnavailable, code, "not live")
+	assert.Falsef(t, report.Live, "not live")
+	assert.GreaterOrEqualf(t, time.Since(report.CheckedAt), 90*time.Millisecond, "3 intervals since last check")
+	code, report, err = getHealth(t, http.MethodGet, "")
+	assert.Nilf(t, err, "get health")
+	assert.Equalf(t, http.StatusOK, code, "ready probe uses the cached result")
+	assert.Truef(t, report.Ready, "ready")
+
+	unblock()
+	assert.Eventuallyf(t, func() bool {
+		code, _, _ := getHealth(t, http.MethodGet, "?probe=live")
+		return code == http.StatusOK
+	}, 3*time.Second, 10*time.Millisecond, "live again when checks resumed")
+}
+
+func TestHealthDisabled(t *testing.T) {
+	setToggle(nil, false)
+	c := newConfig(t, `{"ext": {"test_toggle": {}}, "health_interval": "-1s"}`)
+	err := c.Start()
+	assert.Nilf(t, err, "start")
+	defer c.Stop()
+
+	_, report, err := getHealth(t, http.MethodGet, "")
+	assert.Nilf(t, err, "get health")
+	checkedAt := report.CheckedAt
+
+	setToggle(errors.New("toggle down"), false)
+	defer setToggle(nil, false)
+	time.Sleep(100 * time.Millisecond)
+	code, report, err := getHealth(t, http.MethodGet, "")
+	assert.Nilf(t, err, "get health")
+	assert.Equalf(t, http.StatusOK, code, "result of Start kept when disabled")
+	assert.Truef(t, report.Ready, "ready")
+	assert.Equalf(t, checkedAt, report.CheckedAt, "not checked when disabled")
+	code, report, err = getHealth(t, http.MethodGet, "?probe=live")
+	assert.Nilf(t, err, "get health")
+	assert.Equalf(t, http.StatusOK, code, "always live when disabled")
+	assert.Truef(t, report.Live, "live")
+}
+
+func TestHealthStatus(t *testing.T) {
+	code, report, err := getHealth(t, http.MethodGet, "")
+	assert.Nilf(t, err, "not running")
+	assert.Equalf(t, http.StatusServiceUnavailable, code, "not running")
+	assert.Falsef(t, report.Ready, "not running")
+	code, _, err = getHealth(t, http.MethodGet, "?probe=live")
+	assert.Nilf(t, err, "not running")
+	assert.Equalf(t, http.StatusServiceUnavailable, code, "not running")
+
+	_, _, err = getHealth(t, http.MethodPost, "")
+	var apiErr caddy.APIError
+	assert.Truef(t, errors.As(err, &apiErr), "method not allowed")
+	assert.Equalf(t, http.StatusMethodNotAllowed, apiErr.HTTPStatus, "method not allowed")
+
+	_, _, err = getHealth(t, http.MethodGet, "?probe=unknown")
+	assert.Truef(t, errors.As(err, &apiErr), "unknown probe")
+	assert.Equalf(t, http.StatusBadRequest, apiErr.HTTPStatus, "unknown probe")
+
+	setToggle(nil, false)
+	c := newConfig(t, `{"ext": {"test_toggle": {}}}`)
+	err = c.Start()
+	assert.Nilf(t, err, "start")
+	code, _, err = getHealth(t, http.MethodGet, "")
+	assert.Nilf(t, err, "get health")
+	assert.Equalf(t, http.StatusOK, code, "running")
+	err = c.Stop()
+	assert.Nilf(t, err, "stop")
+	code, _, err = getHealth(t, http.MethodGet, "")
+	assert.Nilf(t, err, "get health")
+	assert.Equalf(t, http.StatusServiceUnavailable, code, "stopped")
+}
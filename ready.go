@@ -0,0 +1,277 @@
+package caddyconfig
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(AdminAPI{})
+}
+
+// ReadyBackoff Start时重试未就绪模块的退避策略，默认从500ms开始每次翻倍，最大5s
+type ReadyBackoff struct {
+	// Initial 首次重试的间隔，默认500ms
+	Initial caddy.Duration `json:"initial,omitempty"`
+	// Max 重试间隔的最大值，默认5s
+	Max caddy.Duration `json:"max,omitempty"`
+	// Multiplier 每次重试间隔的倍数，默认2
+	Multiplier float64 `json:"multiplier,omitempty"`
+}
+
+// defaults of ReadyBackoff
+const (
+	DefaultReadyInitial    = 500 * time.Millisecond
+	DefaultReadyMax        = 5 * time.Second
+	DefaultReadyMultiplier = 2.0
+)
+
+// next 返回下一次重试的间隔，wait为0表示首次重试
+func (b *ReadyBackoff) next(wait time.Duration) time.Duration {
+	initial, max, multiplier := DefaultReadyInitial, DefaultReadyMax, DefaultReadyMultiplier
+	if b != nil {
+		if b.Initial > 0 {
+			initial = time.Duration(b.Initial)
+		}
+		if b.Max > 0 {
+			max = time.Duration(b.Max)
+		}
+		if b.Multiplier >= 1 {
+			multiplier = b.Multiplier
+		}
+	}
+	if wait <= 0 {
+		wait = initial
+	} else {
+		wait = time.Duration(float64(wait) * multiplier)
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait
+}
+
+// readyStatus 各Ready模块的检查状态
+type readyStatus struct {
+	sync.Mutex
+	mods map[string]*ReadyStatus
+}
+
+// ReadyStatus 模块的就绪检查状态
+type ReadyStatus struct {
+	Ready bool `json:"ready"`
+	// Error 最近一次检查的错误信息，就绪为空
+	Error string `json:"error,omitempty"`
+	// Attempts 检查的次数
+	Attempts  int       `json:"attempts"`
+	CheckedAt time.Time `json:"checked_at,omitempty"`
+	// ReadyAt 首次就绪的时间
+	ReadyAt *time.Time `json:"ready_at,omitempty"`
+	// LastSuccess 最近一次就绪的时间
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// LastError 最近一次未就绪的错误信息，就绪后仍保留
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// running 当前运行中的Config，用于admin api
+var running = struct {
+	sync.RWMutex
+	c *Config
+}{}
+
+func setRunning(c *Config) {
+	running.Lock()
+	defer running.Unlock()
+	running.c = c
+}
+
+// unsetRunning NOTE: reload时新Config先Start，旧Config后Stop，所以只清除自己
+func unsetRunning(c *Config) {
+	running.Lock()
+	defer running.Unlock()
+	if running.c == c {
+		running.c = nil
+	}
+}
+
+func getRunning() *Config {
+	running.RLock()
+	defer running.RUnlock()
+	return running.c
+}
+
+// register 按顺序登记Config直接Provision的子配置(如logging、pool)和直接加载的模块(eigenkey、store、ext)，实现了Ready
+// 的在Start时会被检查，Stop时逆序清理，name为配置路径或模块id，嵌套加载的模块见`RegisterReady`
+func (c *Config) register(name string, mod any) {
+	c.resources = append(c.resources, resource{name: name, mod: mod})
+	c.collectReady(name, mod)
+}
+
+type configKey struct{}
+
+// RegisterReady 登记config app下嵌套加载的Ready模块(如扩展在Provision中通过ctx.LoadModule加载的模块)，使其在Start时
+// 被检查并在admin api中报告，name建议使用模块id或配置路径；Config的直接子模块会被自动收集，无需登记。
+// 不在config app下Provision时返回false
+func RegisterReady(ctx caddy.Context, name string, r Ready) bool {
+	c, ok := ctx.Value(configKey{}).(*Config)
+	if !ok {
+		return false
+	}
+	c.collectReady(name, r)
+	return true
+}
+
+func (c *Config) collectReady(name string, mod any) {
+	readyMod, ok := mod.(Ready)
+	if !ok {
+		return
+	}
+	c.readyMods[name] = readyMod
+	c.readyStatus.mods[name] = &ReadyStatus{}
+}
+
+// checkReady 检查所有Ready模块并更新各模块的状态，返回未就绪模块的错误
+func (c *Config) checkReady() map[string]error {
+	errs := make(map[string]error)
+	for name, readyMod := range c.readyMods {
+		err := readyMod.Ready()
+		if err != nil {
+			errs[name] = err
+		}
+		c.readyStatus.Lock()
+		status := c.readyStatus.mods[name]
+		status.Attempts++
+		status.CheckedAt = time.Now()
+		status.Ready = err == nil
+		status.Error = ""
+		checkedAt := status.CheckedAt
+		if err != nil {
+			status.Error = err.Error()
+			status.LastError = status.Error
+			status.LastErrorAt = &checkedAt
+		} else {
+			status.LastSuccess = &checkedAt
+			if status.ReadyAt == nil {
+				status.ReadyAt = &checkedAt
+			}
+		}
+		c.readyStatus.Unlock()
+	}
+	return errs
+}
+
+// ReadyStatuses 返回各Ready模块最近一次检查的状态
+func (c *Config) ReadyStatuses() map[string]ReadyStatus {
+	c.readyStatus.Lock()
+	defer c.readyStatus.Unlock()
+	statuses := make(map[string]ReadyStatus, len(c.readyStatus.mods))
+	for name, status := range c.readyStatus.mods {
+		statuses[name] = *status
+	}
+	return statuses
+}
+
+// waitReady 按ReadyBackoff重试直到所有Ready模块就绪，超过ReadyTimeout则返回错误
+func (c *Config) waitReady() error {
+	deadline := time.Now().Add(time.Duration(c.ReadyTimeout))
+	var backoff time.Duration
+	for {
+		errs := c.checkReady()
+		if len(errs) == 0 {
+			return nil
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return readyError(errs)
+		}
+		backoff = c.ReadyBackoff.next(backoff)
+		if wait > backoff {
+			wait = backoff
+		}
+		c.logger.Info("waiting for mods ready", zap.Duration("wait", wait), zap.Error(readyError(errs)))
+		time.Sleep(wait)
+	}
+}
+
+func readyError(errs map[string]error) error {
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, errors.Errorf("mod %s Ready check failed: %v", name, errs[name]).Error())
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// AdminAPI 提供config app的admin api:
+//
+// - GET /config/ready: 所有Ready模块都就绪返回200，否则返回503，并返回各模块的检查状态，可用于kubernetes readiness probe
+// - GET /config/health: 返回后台周期检查缓存的结果，见`HealthReport`
+type AdminAPI struct{}
+
+// ReadyReport 就绪检查结果
+type ReadyReport struct {
+	Ready bool `json:"ready"`
+	// Mods 各Ready模块的检查状态，key为模块id或配置路径，如`config.ext.dynconf`、`config.http.clients`
+	Mods map[string]ReadyStatus `json:"mods"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.config",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+// Routes implement caddy.AdminRouter
+func (a AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/config/ready",
+			Handler: caddy.AdminHandlerFunc(a.handleReady),
+		},
+		{
+			Pattern: "/config/health",
+			Handler: caddy.AdminHandlerFunc(a.handleHealth),
+		},
+	}
+}
+
+func (a AdminAPI) handleReady(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errors.Errorf("method %s not allowed", r.Method),
+		}
+	}
+	report := ReadyReport{Mods: make(map[string]ReadyStatus)}
+	c := getRunning()
+	if c != nil {
+		errs := c.checkReady()
+		report.Mods = c.ReadyStatuses()
+		report.Ready = len(errs) == 0
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return json.NewEncoder(w).Encode(report)
+}
+
+// Interface guard
+var (
+	_ caddy.AdminRouter = (*AdminAPI)(nil)
+)
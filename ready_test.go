@@ -0,0 +1,192 @@
+package caddyconfig_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	caddyconfig "github.com/ccmonky/caddy-config"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	caddy.RegisterModule(Delayed{})
+	caddy.RegisterModule(Nested{})
+}
+
+// Delayed is ready after the delay since provisioned
+type Delayed struct {
+	Delay caddy.Duration `json:"delay,omitempty"`
+
+	readyAt time.Time
+}
+
+func (Delayed) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "config.ext.test_delayed",
+		New: func() caddy.Module { return new(Delayed) },
+	}
+}
+
+func (d *Delayed) Provision(ctx caddy.Context) error {
+	d.readyAt = time.Now().Add(time.Duration(d.Delay))
+	return nil
+}
+
+func (d *Delayed) Ready() error {
+	if wait := time.Until(d.readyAt); wait > 0 {
+		return fmt.Errorf("ready after %v", wait)
+	}
+	return nil
+}
+
+// Nested registers a nested Delayed via RegisterReady
+type Nested struct {
+	Delay caddy.Duration `json:"delay,omitempty"`
+
+	registered bool
+}
+
+func (Nested) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "config.ext.test_nested",
+		New: func() caddy.Module { return new(Nested) },
+	}
+}
+
+func (n *Nested) Provision(ctx caddy.Context) error {
+	inner := &Delayed{Delay: n.Delay}
+	err := inner.Provision(ctx)
+	if err != nil {
+		return err
+	}
+	n.registered = caddyconfig.RegisterReady(ctx, "config.ext.test_nested.inner", inner)
+	return nil
+}
+
+func newConfig(t *testing.T, data string) *caddyconfig.Config {
+	c := &caddyconfig.Config{}
+	err := json.Unmarshal([]byte(data), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	t.Cleanup(cancel)
+	err = c.Provision(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func getReady(t *testing.T) (int, caddyconfig.ReadyReport) {
+	handler := caddyconfig.AdminAPI{}.Routes()[0].Handler
+	w := httptest.NewRecorder()
+	err := handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/config/ready", nil))
+	assert.Nilf(t, err, "get ready")
+	var report caddyconfig.ReadyReport
+	err = json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Nilf(t, err, "get ready")
+	return w.Code, report
+}
+
+func TestStartNotReady(t *testing.T) {
+	c := newConfig(t, `{"ext": {"test_delayed": {"delay": "1h"}}, "ready_timeout": "50ms"}`)
+	start := time.Now()
+	err := c.Start()
+	assert.NotNilf(t, err, "not ready")
+	assert.Containsf(t, err.Error(), "config.ext.test_delayed", "not ready mod")
+	assert.GreaterOrEqualf(t, time.Since(start), 50*time.Millisecond, "wait until ready timeout")
+
+	code, report := getReady(t)
+	assert.Equalf(t, http.StatusServiceUnavailable, code, "not running")
+	assert.Falsef(t, report.Ready, "not running")
+}
+
+func TestStartWaitReady(t *testing.T) {
+	c := newConfig(t, `{
+		"ext": {"test_delayed": {"delay": "100ms"}},
+		"ready_timeout": "3s",
+		"ready_backoff": {"initial": "10ms"}
+	}`)
+	start := time.Now()
+	err := c.Start()
+	assert.Nilf(t, err, "ready")
+	defer c.Stop()
+	assert.GreaterOrEqualf(t, time.Since(start), 100*time.Millisecond, "wait until ready")
+
+	code, report := getReady(t)
+	assert.Equalf(t, http.StatusOK, code, "ready")
+	assert.Truef(t, report.Ready, "ready")
+	status := report.Mods["config.ext.test_delayed"]
+	assert.Truef(t, status.Ready, "mod ready")
+	assert.Greaterf(t, status.Attempts, 1, "retried")
+	assert.NotEmptyf(t, status.LastError, "last error kept")
+	assert.NotNilf(t, status.ReadyAt, "ready at")
+
+	err = c.Stop()
+	assert.Nilf(t, err, "stop")
+	code, _ = getReady(t)
+	assert.Equalf(t, http.StatusServiceUnavailable, code, "stopped")
+}
+
+func TestStartBackoff(t *testing.T) {
+	// the first retry waits the whole ready timeout
+	c := newConfig(t, `{
+		"ext": {"test_delayed": {"delay": "1h"}},
+		"ready_timeout": "300ms",
+		"ready_backoff": {"initial": "1s"}
+	}`)
+	err := c.Start()
+	assert.NotNilf(t, err, "not ready")
+	assert.Equalf(t, 2, c.ReadyStatuses()["config.ext.test_delayed"].Attempts, "initial backoff")
+
+	// the backoff grows by multiplier and is capped by max
+	c = newConfig(t, `{
+		"ext": {"test_delayed": {"delay": "1h"}},
+		"ready_timeout": "300ms",
+		"ready_backoff": {"initial": "10ms", "max": "20ms", "multiplier": 10}
+	}`)
+	err = c.Start()
+	assert.NotNilf(t, err, "not ready")
+	attempts := c.ReadyStatuses()["config.ext.test_delayed"].Attempts
+	assert.GreaterOrEqualf(t, attempts, 8, "max backoff")
+	assert.LessOrEqualf(t, attempts, 17, "max backoff")
+
+	// without max the second wait(100ms) and third wait(1s) exhaust the ready timeout
+	c = newConfig(t, `{
+		"ext": {"test_delayed": {"delay": "1h"}},
+		"ready_timeout": "300ms",
+		"ready_backoff": {"initial": "10ms", "multiplier": 10}
+	}`)
+	err = c.Start()
+	assert.NotNilf(t, err, "not ready")
+	assert.Equalf(t, 4, c.ReadyStatuses()["config.ext.test_delayed"].Attempts, "multiplier backoff")
+}
+
+func TestRegisterReady(t *testing.T) {
+	c := newConfig(t, `{"ext": {"test_nested": {"delay": "1h"}}, "ready_timeout": "50ms"}`)
+	err := c.Start()
+	assert.NotNilf(t, err, "nested not ready")
+	assert.Containsf(t, err.Error(), "config.ext.test_nested.inner", "nested not ready mod")
+	_, ok := c.ReadyStatuses()["config.ext.test_nested.inner"]
+	assert.Truef(t, ok, "nested status")
+
+	c = newConfig(t, `{"ext": {"test_nested": {"delay": "50ms"}}, "ready_timeout": "3s"}`)
+	err = c.Start()
+	assert.Nilf(t, err, "nested ready")
+	defer c.Stop()
+	code, report := getReady(t)
+	assert.Equalf(t, http.StatusOK, code, "ready")
+	assert.Truef(t, report.Mods["config.ext.test_nested.inner"].Ready, "nested ready")
+
+	n := &Nested{}
+	err = n.Provision(caddy.Context{Context: context.Background()})
+	assert.Nilf(t, err, "provision outside config app")
+	assert.Falsef(t, n.registered, "not registered outside config app")
+}